@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"./kumo"
+	"github.com/sww/dumblog"
+)
+
+// Runs kumo filter --test, which shows what the filters would do to NZBs
+// without downloading them.
+func runFilter(config *kumo.Config, args []string) error {
+	flags := flag.NewFlagSet("filter", flag.ExitOnError)
+	test := flags.Bool("test", false, "list the files each rule would keep or drop")
+	flags.Parse(args)
+
+	if !*test {
+		return errors.New("usage: kumo filter --test file.nzb...")
+	}
+
+	filter, err := kumo.NewConfigFilter(config)
+	if err != nil {
+		return err
+	}
+	filter.Logger = dumblog.New(config.Debug)
+
+	nzbs, err := readNZBs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	printDecisions(os.Stdout, filter, kumo.Merge(nzbs...))
+	return nil
+}
+
+// Prints the files of nzb grouped by the rule that kept or dropped them.
+func printDecisions(w io.Writer, filter *kumo.Filter, nzb *kumo.NZB) {
+	type decision struct {
+		keep bool
+		rule string
+	}
+
+	var order []decision
+	files := make(map[decision][]string)
+	for _, file := range nzb.Files {
+		keep, rule := filter.Decide(&file)
+		if rule == "" && keep {
+			rule = "no rule"
+		} else if rule == "" {
+			rule = "no include rule"
+		}
+
+		d := decision{keep, rule}
+		if _, ok := files[d]; !ok {
+			order = append(order, d)
+		}
+		name := file.ParsedSubject().Filename
+		if name == "" {
+			name = file.Subject
+		}
+		files[d] = append(files[d], name)
+	}
+
+	for _, d := range order {
+		action := "drops"
+		if d.keep {
+			action = "keeps"
+		}
+		fmt.Fprintf(w, "%s %s %d files:\n", d.rule, action, len(files[d]))
+		for _, name := range files[d] {
+			fmt.Fprintf(w, "  %s\n", name)
+		}
+	}
+}
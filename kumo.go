@@ -4,10 +4,23 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strings"
 
 	"./kumo"
 )
 
+// A flag that can be given more than once.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func main() {
 	configName := flag.String("config", "config.json", "config file")
 	debug := flag.Bool("debug", false, "show debug statements")
@@ -15,6 +28,9 @@ func main() {
 	quiet := flag.Bool("quiet", false, "hide the progress output")
 	par2 := flag.Bool("par2", false, "get only par2 files")
 	rm := flag.Bool("rm", false, "remove nzb file after download")
+	var includes, excludes stringList
+	flag.Var(&includes, "include", "only get files matching this rule, such as \"ext=.mkv,.srt minsize=10MB\"")
+	flag.Var(&excludes, "exclude", "skip files matching this rule, such as \"poster=spam maxage=30d\"")
 
 	flag.Parse()
 
@@ -38,16 +54,70 @@ func main() {
 	config.Quiet = *quiet
 	config.PAR2 = *par2
 
+	for _, rules := range []struct {
+		values  stringList
+		include bool
+	}{{includes, true}, {excludes, false}} {
+		for i, value := range rules.values {
+			rule, err := kumo.ParseRule(value, rules.include)
+			if err != nil {
+				log.Fatalf("Bad rule %s %q: %v\n", kumo.FlagLabel(rules.include, i+1), value, err)
+			}
+			config.FlagRules = append(config.FlagRules, rule)
+		}
+	}
+
+	parts := kumo.AllSettings
+	switch files[0] {
+	case "filter", "nzb":
+		parts = kumo.FilterSettings
+	case "check":
+		parts = kumo.ServerSettings | kumo.FilterSettings
+	}
+	if err := config.Validate(parts); err != nil {
+		log.Fatalf("Error in config:\n%v\n", err)
+	}
+
+	if files[0] == "filter" {
+		if err := runFilter(config, files[1:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	if files[0] == "nzb" {
+		if err := runNZB(config, files[1:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
 	kumo := kumo.New(config)
+
+	if files[0] == "check" {
+		for _, filename := range files[1:] {
+			result, err := kumo.Check(filename)
+			if err != nil {
+				log.Printf("Error checking \"%s\": %v", filename, err)
+				continue
+			}
+			result.Print(os.Stdout)
+		}
+		return
+	}
+
 	for _, filename := range files {
 		if _, err := os.Stat(filename); err != nil {
 			log.Printf("\"%s\" does not exist.", filename)
 			continue
 		}
-		if kumo.Get(filename) != nil {
+		result, err := kumo.Get(filename)
+		if err != nil {
 			log.Printf("Error: %v", err)
+		} else if result.Failed() {
+			log.Printf("\"%s\" failed: %d unrepairable slices, %d archives not extracted", filename, result.Unrepairable, len(result.ExtractErrors))
 		}
-		if *rm {
+		if *rm && err == nil {
 			os.Remove(filename)
 		}
 	}
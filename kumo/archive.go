@@ -0,0 +1,122 @@
+package kumo
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrUnsupportedArchive = errors.New("unsupported archive")
+
+// Joins a set of split files back into the file they were split from, which
+// is named after the first without its .001 extension.
+func joinSplit(split archive) error {
+	if split.First == "" {
+		return fmt.Errorf("%w: first volume", ErrMissingVolume)
+	}
+
+	for i, volume := range split.Volumes {
+		n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Ext(volume), "."))
+		if n != i+1 {
+			return fmt.Errorf("%w: volume %03d", ErrMissingVolume, i+1)
+		}
+	}
+
+	path := strings.TrimSuffix(split.First, filepath.Ext(split.First))
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	for _, volume := range split.Volumes {
+		if err := appendFile(out, volume); err != nil {
+			out.Close()
+			os.Remove(path)
+			return err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+func appendFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Extracts the zip file at path into dest.
+func extractZip(path, dest string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+	}
+	defer r.Close()
+
+	root := filepath.Clean(dest) + string(filepath.Separator)
+	for _, f := range r.File {
+		// archive/zip can't decrypt, so encrypted zips need 7z.
+		if f.Flags&0x1 != 0 {
+			return fmt.Errorf("%w: %s is encrypted", ErrUnsupportedArchive, f.Name)
+		}
+
+		target := filepath.Join(dest, f.Name)
+		if !strings.HasPrefix(target, root) {
+			return fmt.Errorf("%w: %s is outside the archive", ErrCorruptArchive, f.Name)
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0775); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0775); err != nil {
+			return err
+		}
+		if err := extractZipFile(f, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractZipFile(f *zip.File, target string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+	}
+	defer rc.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, rc); err != nil {
+		if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: %s: %v", ErrCorruptArchive, f.Name, err)
+		}
+		return err
+	}
+
+	return out.Close()
+}
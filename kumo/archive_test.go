@@ -0,0 +1,77 @@
+package kumo
+
+import (
+	"archive/zip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJoinSplit(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"movie.mkv.001": "one ",
+		"movie.mkv.002": "two ",
+		"movie.mkv.003": "three",
+		"short.bin.001": "a",
+		"short.bin.003": "c",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+	}
+
+	splits, err := splitSets(dir)
+	if err != nil || len(splits) != 2 {
+		t.Fatalf("splitSets() returned %+v, %v", splits, err)
+	}
+
+	if err := joinSplit(splits[0]); err != nil {
+		t.Fatalf("joinSplit() returned %v", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "movie.mkv")); string(data) != "one two three" {
+		t.Errorf("Joined %q, want %q", data, "one two three")
+	}
+
+	if err := joinSplit(splits[1]); !errors.Is(err, ErrMissingVolume) || err.Error() != "missing volume: volume 002" {
+		t.Errorf("joinSplit() returned %v, want ErrMissingVolume for volume 002", err)
+	}
+	// A volume that can't be read leaves no partial file behind.
+	os.Remove(filepath.Join(dir, "movie.mkv"))
+	os.Remove(filepath.Join(dir, "movie.mkv.002"))
+	if err := joinSplit(splits[0]); err == nil {
+		t.Error("joinSplit() returned nil with a volume gone")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "movie.mkv")); !os.IsNotExist(err) {
+		t.Errorf("joinSplit() left movie.mkv behind: %v", err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "subs.zip")
+
+	f, _ := os.Create(path)
+	w := zip.NewWriter(f)
+	for name, data := range map[string]string{
+		"subs/en.srt": "hello",
+		"readme.txt":  "read me",
+	} {
+		entry, _ := w.Create(name)
+		entry.Write([]byte(data))
+	}
+	w.Close()
+	f.Close()
+
+	if err := extractZip(path, dir); err != nil {
+		t.Fatalf("extractZip() returned %v", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "subs", "en.srt")); string(data) != "hello" {
+		t.Errorf("Extracted %q, want %q", data, "hello")
+	}
+
+	ioutil.WriteFile(path, []byte("not a zip"), 0644)
+	if err := extractZip(path, dir); !errors.Is(err, ErrCorruptArchive) {
+		t.Errorf("extractZip() returned %v, want ErrCorruptArchive", err)
+	}
+}
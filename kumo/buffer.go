@@ -0,0 +1,69 @@
+package kumo
+
+import (
+	"bytes"
+	"sync"
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	return buffer
+}
+
+func putBuffer(buffer *bytes.Buffer) {
+	if buffer != nil {
+		bufferPool.Put(buffer)
+	}
+}
+
+// memoryBudget limits the bytes held in article and decoded part buffers
+// between downloading a segment and writing it out.
+type memoryBudget struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	budget := &memoryBudget{limit: limit}
+	budget.freed = sync.NewCond(&budget.mu)
+	return budget
+}
+
+// Blocks until n bytes are available. A single request larger than the
+// whole budget is let through once nothing else is held.
+func (m *memoryBudget) acquire(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for m.used > 0 && m.used+n > m.limit {
+		m.freed.Wait()
+	}
+	m.used += n
+}
+
+// Takes n bytes if they are available, without blocking.
+func (m *memoryBudget) tryAcquire(n int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.used > 0 && m.used+n > m.limit {
+		return false
+	}
+	m.used += n
+	return true
+}
+
+func (m *memoryBudget) release(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.used -= n
+	m.freed.Broadcast()
+}
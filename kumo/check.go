@@ -0,0 +1,267 @@
+package kumo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sww/kumo/nntp"
+)
+
+// Number of STAT commands sent down a connection at once.
+const statBatchSize = 100
+
+var par2VolumeRe = regexp.MustCompile(`(?i)\.vol\d+\+(\d+)\.par2`)
+
+type FileCheck struct {
+	Subject         string
+	Segments        int
+	MissingSegments int
+	MissingBytes    int64
+}
+
+type CheckResult struct {
+	Files           []FileCheck
+	TotalSegments   int
+	MissingSegments int
+	TotalBytes      int64
+	MissingBytes    int64
+	// Recovery blocks and bytes in the PAR2 volumes that are fully available.
+	RecoveryBlocks int
+	RecoveryBytes  int64
+}
+
+// Returns the share of the NZB's bytes that are missing, from 0 to 1.
+func (c *CheckResult) MissingShare() float64 {
+	if c.TotalBytes == 0 {
+		return 0
+	}
+
+	return float64(c.MissingBytes) / float64(c.TotalBytes)
+}
+
+// Returns whether the available PAR2 recovery data is at least as large as
+// the data missing from the other files. This is an estimate: the block size
+// is not known until the PAR2 index is downloaded.
+func (c *CheckResult) Repairable() bool {
+	missing := int64(0)
+	for _, file := range c.Files {
+		if !isPAR2(file.Subject) {
+			missing += file.MissingBytes
+		}
+	}
+
+	return missing <= c.RecoveryBytes
+}
+
+func (c *CheckResult) Print(w io.Writer) {
+	for _, file := range c.Files {
+		if file.MissingSegments > 0 {
+			fmt.Fprintf(w, "%s (%d/%d) segments missing: %s\n", red("✘"), file.MissingSegments, file.Segments, file.Subject)
+		}
+	}
+
+	fmt.Fprintf(w, "%d/%d segments missing, %s/%s (%.2f%%)\n", c.MissingSegments, c.TotalSegments, ByteSize(c.MissingBytes).String(), ByteSize(c.TotalBytes).String(), c.MissingShare()*100)
+	if c.MissingSegments == 0 {
+		fmt.Fprintf(w, "%s Complete\n", green(PREFIX_COMPLETE_OK))
+	} else if c.Repairable() {
+		fmt.Fprintf(w, "%s Likely repairable with %d PAR2 recovery blocks (%s)\n", green(PREFIX_COMPLETE_OK), c.RecoveryBlocks, ByteSize(c.RecoveryBytes).String())
+	} else {
+		fmt.Fprintf(w, "%s Not repairable with %d PAR2 recovery blocks (%s)\n", red(PREFIX_COMPLETE_BROKEN), c.RecoveryBlocks, ByteSize(c.RecoveryBytes).String())
+	}
+}
+
+func isPAR2(subject string) bool {
+	return strings.EqualFold(ParseSubject(subject).Extension(), ".par2")
+}
+
+// Returns the number of recovery blocks in a PAR2 volume, from the
+// .volXX+YY.par2 naming convention.
+func par2Blocks(subject string) int {
+	matches := par2VolumeRe.FindStringSubmatch(ParseSubject(subject).Filename)
+	if matches == nil {
+		return 0
+	}
+
+	blocks, _ := strconv.Atoi(matches[1])
+	return blocks
+}
+
+// Check reports how much of the NZB in filename is available on the
+// configured servers, without downloading any article bodies.
+func (k *Kumo) Check(filename string) (*CheckResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	nzb, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, problem := range nzb.Normalize() {
+		k.logger.Printf("[CHECK] %v", problem)
+	}
+
+	return k.CheckNZB(nzb)
+}
+
+// CheckNZB is Check for an NZB that has already been parsed.
+func (k *Kumo) CheckNZB(nzb *NZB) (*CheckResult, error) {
+	var ids []string
+	for _, nzbFile := range nzb.Files {
+		for _, segment := range nzbFile.Segments {
+			ids = append(ids, segment.Segment)
+		}
+	}
+
+	missing, err := k.download.stat(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkResult(nzb, missing), nil
+}
+
+func checkResult(nzb *NZB, missing map[string]bool) *CheckResult {
+	result := &CheckResult{TotalBytes: nzb.Size()}
+	for _, nzbFile := range nzb.Files {
+		check := FileCheck{Subject: nzbFile.Subject, Segments: len(nzbFile.Segments)}
+		size := int64(0)
+		for _, segment := range nzbFile.Segments {
+			size += segment.Bytes
+			if missing[segment.Segment] {
+				check.MissingSegments++
+				check.MissingBytes += segment.Bytes
+			}
+		}
+
+		result.Files = append(result.Files, check)
+		result.TotalSegments += check.Segments
+		result.MissingSegments += check.MissingSegments
+		result.MissingBytes += check.MissingBytes
+
+		if check.MissingSegments == 0 {
+			if blocks := par2Blocks(nzbFile.Subject); blocks > 0 {
+				result.RecoveryBlocks += blocks
+				result.RecoveryBytes += size
+			}
+		}
+	}
+
+	return result
+}
+
+// Returns the set of ids missing from every priority tier. Ids are only
+// checked on a tier if they were missing from all of the earlier ones.
+func (d *Download) stat(ids []string) (map[string]bool, error) {
+	missing := make(map[string]bool)
+	for _, id := range ids {
+		missing[id] = true
+	}
+
+	for _, pool := range d.ConnectionPools {
+		var remaining []string
+		for id := range missing {
+			remaining = append(remaining, id)
+		}
+		if len(remaining) == 0 {
+			break
+		}
+
+		found, err := d.statPool(pool, remaining)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range found {
+			delete(missing, id)
+		}
+	}
+
+	return missing, nil
+}
+
+// Pipelines STAT for ids across every connection in pool, returning the ids
+// that exist.
+func (d *Download) statPool(pool *ConnectionPool, ids []string) ([]string, error) {
+	batches := make(chan []string)
+	go func() {
+		for i := 0; i < len(ids); i += statBatchSize {
+			end := i + statBatchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			batches <- ids[i:end]
+		}
+		close(batches)
+	}()
+
+	var mu sync.Mutex
+	var found []string
+	var failed error
+	var wait sync.WaitGroup
+
+	for i := 0; i < pool.size; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for batch := range batches {
+				errs, err := d.statBatch(pool, batch)
+
+				mu.Lock()
+				if err != nil {
+					failed = err
+				}
+				for j, id := range batch {
+					if err == nil && errs[j] == nil {
+						found = append(found, id)
+					} else if errs != nil && errs[j] != nil && !errors.Is(errs[j], nntp.ErrNoSuchArticle) {
+						d.Logger.Printf("[CHECK] STAT %v got err: %v", id, errs[j])
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wait.Wait()
+
+	return found, failed
+}
+
+// Sends STAT for one batch of ids, retrying on a fresh connection when the
+// connection fails.
+func (d *Download) statBatch(pool *ConnectionPool, batch []string) ([]error, error) {
+	ids := make([]string, len(batch))
+	for i, id := range batch {
+		ids[i] = fmt.Sprintf("<%s>", id)
+	}
+
+	var err error
+	for attempt := 0; attempt <= d.Retries; attempt++ {
+		connection, acquireErr := pool.acquire()
+		if acquireErr != nil {
+			return nil, acquireErr
+		}
+
+		var errs []error
+		errs, err = connection.client.StatAll(ids)
+		if err == nil {
+			pool.connections <- connection
+			return errs, nil
+		}
+
+		d.Logger.Printf("[CHECK] Replacing connection to %v after err: %v", connection.server.Host, err)
+		pool.replace(connection)
+	}
+
+	return nil, err
+}
@@ -0,0 +1,31 @@
+package kumo
+
+import (
+	"testing"
+)
+
+func Test_checkResult(t *testing.T) {
+	nzb := &NZB{
+		Files: []File{
+			{Subject: `"foo.rar" yEnc (1/2)`, Segments: []Segment{{Bytes: 100, Segment: "1@foo"}, {Bytes: 100, Segment: "2@foo"}}},
+			{Subject: `"foo.par2" yEnc (1/1)`, Segments: []Segment{{Bytes: 10, Segment: "3@foo"}}},
+			{Subject: `"foo.vol00+01.par2" yEnc (1/1)`, Segments: []Segment{{Bytes: 150, Segment: "4@foo"}}},
+			{Subject: `"foo.vol01+02.par2" yEnc (1/1)`, Segments: []Segment{{Bytes: 300, Segment: "5@foo"}}},
+		},
+	}
+
+	result := checkResult(nzb, map[string]bool{"2@foo": true, "5@foo": true})
+
+	if result.MissingSegments != 2 || result.TotalSegments != 5 {
+		t.Errorf("Returned %d/%d missing segments, want 2/5", result.MissingSegments, result.TotalSegments)
+	}
+	if result.MissingBytes != 400 || result.TotalBytes != 660 {
+		t.Errorf("Returned %d/%d missing bytes, want 400/660", result.MissingBytes, result.TotalBytes)
+	}
+	if result.RecoveryBlocks != 1 || result.RecoveryBytes != 150 {
+		t.Errorf("Returned %d recovery blocks (%d bytes), want 1 (150 bytes)", result.RecoveryBlocks, result.RecoveryBytes)
+	}
+	if !result.Repairable() {
+		t.Errorf("Repairable() returned false, want true")
+	}
+}
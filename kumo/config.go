@@ -2,10 +2,36 @@ package kumo
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sww/kumo/nntp"
 )
 
+type Server struct {
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	Connections int
+	SSL         bool
+	StartTLS    bool
+	TLS         nntp.TLSConfig
+	Compress    bool
+	// Pipeline is the number of BODY commands kept in flight on each
+	// connection.
+	Pipeline int
+	// Priority orders servers into tiers, lowest first. Servers in a later
+	// tier are only used to fill segments missing from the earlier ones.
+	Priority int
+}
+
 type Config struct {
 	Debug       bool
 	DebugFile   string
@@ -15,13 +41,32 @@ type Config struct {
 	Username    string
 	Password    string
 	Port        int
-	Temp        string
 	Download    string
 	SSL         bool
+	StartTLS    bool
+	TLS         nntp.TLSConfig
+	Compress    bool
+	Pipeline    int
+	Memory      int // megabytes of segment buffers held in memory
+	Servers     []Server
+	Retries     int
+	RetryDelay  int // milliseconds before the first retry, doubled on each further retry
 	Filters     []string
 	PAR2        bool
+	Rules       []Rule   // include and exclude rules for files
+	FlagRules   []Rule   `json:"-"` // rules given with -include and -exclude
+	Extract     bool     // unpack archives once they are downloaded and repaired
+	Unpacker    string   // unrar or 7z binary used to extract RAR sets, unrar if unset
+	SevenZip    string   // 7z binary used to extract 7z archives, 7z if unset
+	Cleanup     bool     // remove archive volumes and split files once extracted
+	Passwords   []string // archive passwords, tried in turn
+	// Categories maps NZB categories to the directories they download to,
+	// relative to Download unless absolute.
+	Categories map[string]string
 }
 
+// GetConfig reads a config without checking it. Call Validate with the
+// settings the command uses before using it.
 func GetConfig(f io.Reader) (*Config, error) {
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
@@ -36,3 +81,212 @@ func GetConfig(f io.Reader) (*Config, error) {
 
 	return config, nil
 }
+
+const (
+	defaultPort        = 119
+	defaultSSLPort     = 563
+	defaultConnections = 4
+	defaultRetries     = 3
+)
+
+// ConfigError is a problem with one config field.
+type ConfigError struct {
+	Field string
+	Msg   string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+// ConfigErrors holds every problem Validate found.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ConfigPart selects the settings Validate checks, so a command isn't held
+// up by settings it doesn't use.
+type ConfigPart int
+
+const (
+	// ServerSettings are the servers and how requests to them are retried.
+	ServerSettings ConfigPart = 1 << iota
+	// DownloadSettings are where downloads go and the memory they use.
+	DownloadSettings
+	// FilterSettings are Filters, Rules and FlagRules.
+	FilterSettings
+
+	AllSettings = ServerSettings | DownloadSettings | FilterSettings
+)
+
+// Validate fills in defaults for the ports, connection counts and retries
+// that are unset, and returns ConfigErrors listing every setting in parts that can't
+// work, in the order they appear in Config.
+func (c *Config) Validate(parts ConfigPart) error {
+	var errs ConfigErrors
+	report := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Field: field, Msg: fmt.Sprintf(format, args...)})
+	}
+	nonNegative := func(field string, value int) {
+		if value < 0 {
+			report(field, "must not be negative, got %d", value)
+		}
+	}
+
+	if parts&ServerSettings != 0 && len(c.Servers) == 0 {
+		server := c.ServerList()[0]
+		server.validate("", report)
+		c.Port = server.Port
+		c.Connections = server.Connections
+	}
+
+	if parts&DownloadSettings != 0 {
+		if c.Download == "" {
+			c.Download = "."
+		}
+		if info, err := os.Stat(c.Download); err != nil {
+			report("download", "%v", err)
+		} else if !info.IsDir() {
+			report("download", "%q is not a directory", c.Download)
+		}
+
+		nonNegative("memory", c.Memory)
+	}
+
+	if parts&ServerSettings != 0 {
+		for i := range c.Servers {
+			c.Servers[i].validate(fmt.Sprintf("servers[%d].", i), report)
+		}
+
+		if c.Retries == 0 {
+			c.Retries = defaultRetries
+		}
+		if c.RetryDelay == 0 {
+			c.RetryDelay = int(defaultRetryDelay / time.Millisecond)
+		}
+		nonNegative("retries", c.Retries)
+		nonNegative("retrydelay", c.RetryDelay)
+	}
+
+	if parts&FilterSettings != 0 {
+		if _, err := NewConfigFilter(c); err != nil {
+			for _, ruleErr := range asRuleErrors("filters", err) {
+				report(ruleErr.Rule, "%v", ruleErr.Err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Fills in the server's defaults and reports its problems, with prefix in
+// front of the field names.
+func (s *Server) validate(prefix string, report func(field, format string, args ...interface{})) {
+	if s.Host == "" {
+		report(prefix+"host", "is required")
+	}
+
+	if s.Port == 0 {
+		s.Port = defaultPort
+		if s.SSL {
+			s.Port = defaultSSLPort
+		}
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		report(prefix+"port", "%d is not a port", s.Port)
+	}
+
+	if s.Connections == 0 {
+		s.Connections = defaultConnections
+	}
+	if s.Connections < 0 {
+		report(prefix+"connections", "must not be negative, got %d", s.Connections)
+	}
+
+	if s.SSL && s.StartTLS {
+		report(prefix+"starttls", "can't be used with ssl")
+	}
+	if err := s.TLS.Check(); err != nil {
+		report(prefix+"tls", "%v", err)
+	}
+	if s.Pipeline < 0 {
+		report(prefix+"pipeline", "must not be negative, got %d", s.Pipeline)
+	}
+}
+
+// Returns the configured servers, falling back to the top level
+// Host/Port/Username/Password settings when no Servers are given.
+func (c *Config) ServerList() []Server {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+
+	return []Server{{
+		Host:        c.Host,
+		Port:        c.Port,
+		Username:    c.Username,
+		Password:    c.Password,
+		Connections: c.Connections,
+		SSL:         c.SSL,
+		StartTLS:    c.StartTLS,
+		TLS:         c.TLS,
+		Compress:    c.Compress,
+		Pipeline:    c.Pipeline,
+	}}
+}
+
+// Returns the directory NZBs in category download to.
+func (c *Config) downloadDir(category string) string {
+	for name, dir := range c.Categories {
+		if category == "" || !strings.EqualFold(name, category) {
+			continue
+		}
+		if filepath.IsAbs(dir) {
+			return dir
+		}
+		return filepath.Join(c.Download, dir)
+	}
+
+	return c.Download
+}
+
+func (s *Server) security() nntp.Security {
+	switch {
+	case s.SSL:
+		return nntp.SecurityTLS
+	case s.StartTLS:
+		return nntp.SecurityStartTLS
+	}
+
+	return nntp.SecurityNone
+}
+
+// Groups servers by Priority, lowest priority first.
+func tiers(servers []Server) [][]Server {
+	sorted := make([]Server, len(servers))
+	copy(sorted, servers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	var tiers [][]Server
+	for i, server := range sorted {
+		if i == 0 || server.Priority != sorted[i-1].Priority {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], server)
+	}
+
+	return tiers
+}
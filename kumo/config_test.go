@@ -1,9 +1,12 @@
 package kumo
 
 import (
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/sww/kumo/nntp"
 )
 
 func Test_GetConfig(t *testing.T) {
@@ -14,7 +17,6 @@ func Test_GetConfig(t *testing.T) {
 	   "username": "user",
 	   "password": "pass",
     "port": 119,
-	   "temp": "tmp",
 	   "download": "download",
 	   "filters": ["a", "b"]
 }`)
@@ -23,7 +25,7 @@ func Test_GetConfig(t *testing.T) {
 		t.Fatalf("Config error %v", err)
 	}
 
-	want := Config{Connections: 1, Host: "host", Username: "user", Password: "pass", Port: 119, Temp: "tmp", Download: "download", SSL: false, Filters: []string{"a", "b"}}
+	want := Config{Connections: 1, Host: "host", Username: "user", Password: "pass", Port: 119, Download: "download", SSL: false, Filters: []string{"a", "b"}}
 	if !reflect.DeepEqual(config, &want) {
 		t.Errorf("Returned %+v, want %+v", config, &want)
 	}
@@ -37,7 +39,6 @@ func Test_GetConfigWithSSL(t *testing.T) {
 	   "username": "user",
 	   "password": "pass",
 	   "port": 119,
-	   "temp": "tmp",
 	   "download": "download",
 	   "SSL": true
 }`)
@@ -46,8 +47,136 @@ func Test_GetConfigWithSSL(t *testing.T) {
 		t.Fatalf("Config error %v", err)
 	}
 
-	want := Config{Connections: 1, Host: "host", Username: "user", Password: "pass", Port: 119, Temp: "tmp", Download: "download", SSL: true}
+	want := Config{Connections: 1, Host: "host", Username: "user", Password: "pass", Port: 119, Download: "download", SSL: true}
 	if !reflect.DeepEqual(config, &want) {
 		t.Errorf("Returned %+v, want %+v", config, &want)
 	}
 }
+
+func Test_GetConfigWithServers(t *testing.T) {
+	c := strings.NewReader(`
+{
+	   "servers": [
+	       {"host": "block", "port": 563, "connections": 2, "ssl": true, "priority": 1},
+	       {"host": "main", "port": 119, "connections": 10}
+	   ],
+	   "download": "download"
+}`)
+	config, err := GetConfig(c)
+	if err != nil {
+		t.Fatalf("Config error %v", err)
+	}
+
+	got := tiers(config.ServerList())
+	want := [][]Server{
+		{{Host: "main", Port: 119, Connections: 10}},
+		{{Host: "block", Port: 563, Connections: 2, SSL: true, Priority: 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Returned %+v, want %+v", got, want)
+	}
+}
+
+func Test_ServerListFallback(t *testing.T) {
+	config := Config{Connections: 1, Host: "host", Username: "user", Password: "pass", Port: 119}
+
+	want := []Server{{Host: "host", Port: 119, Username: "user", Password: "pass", Connections: 1}}
+	if got := config.ServerList(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Returned %+v, want %+v", got, want)
+	}
+}
+
+func Test_GetConfigWithTLS(t *testing.T) {
+	c := strings.NewReader(`
+{
+	   "host": "host",
+	   "port": 119,
+	   "starttls": true,
+	   "tls": {
+	       "cafile": "ca.pem",
+	       "fingerprint": "ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab",
+	       "servername": "news.example.com",
+	       "minversion": "1.2"
+	   }
+}`)
+	config, err := GetConfig(c)
+	if err != nil {
+		t.Fatalf("Config error %v", err)
+	}
+
+	want := nntp.TLSConfig{CAFile: "ca.pem", Fingerprint: "ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab:ab", ServerName: "news.example.com", MinVersion: "1.2"}
+	servers := config.ServerList()
+	if !reflect.DeepEqual(servers[0].TLS, want) {
+		t.Errorf("Returned %+v, want %+v", servers[0].TLS, want)
+	}
+	if servers[0].security() != nntp.SecurityStartTLS {
+		t.Errorf("Returned security %v, want %v", servers[0].security(), nntp.SecurityStartTLS)
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	config := Config{Download: "download", Categories: map[string]string{"tv": "shows", "movies": "/films"}}
+
+	tests := map[string]string{
+		"":       "download",
+		"TV":     filepath.Join("download", "shows"),
+		"movies": "/films",
+		"music":  "download",
+	}
+	for category, want := range tests {
+		if got := config.downloadDir(category); got != want {
+			t.Errorf("downloadDir(%q) = %q, want %q", category, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	config := &Config{Servers: []Server{{Host: "main"}, {Host: "ssl", SSL: true, Connections: 8}}, Download: t.TempDir()}
+	if err := config.Validate(AllSettings); err != nil {
+		t.Fatalf("Validate() returned %v", err)
+	}
+
+	want := []Server{{Host: "main", Port: 119, Connections: 4}, {Host: "ssl", Port: 563, Connections: 8, SSL: true}}
+	if !reflect.DeepEqual(config.Servers, want) {
+		t.Errorf("Validate() set servers to %+v, want %+v", config.Servers, want)
+	}
+	if config.Retries != defaultRetries || config.RetryDelay != 1000 {
+		t.Errorf("Validate() set retries to %d and retry delay to %d, want %d and 1000", config.Retries, config.RetryDelay, defaultRetries)
+	}
+
+	config = &Config{
+		Port:     70000,
+		Download: filepath.Join(t.TempDir(), "missing"),
+		Retries:  -1,
+		Filters:  []string{"("},
+	}
+	err := config.Validate(AllSettings)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("Validate() returned %v, want ConfigErrors", err)
+	}
+
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	wantFields := []string{"host", "port", "download", "retries", `filters[0] "("`}
+	if !reflect.DeepEqual(fields, wantFields) {
+		t.Errorf("Validate() reported %q, want %q", fields, wantFields)
+	}
+}
+
+func TestValidateParts(t *testing.T) {
+	config := &Config{Download: filepath.Join(t.TempDir(), "missing"), Rules: []Rule{{MinSize: "huge"}}}
+
+	err := config.Validate(FilterSettings)
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 1 || !strings.HasPrefix(errs[0].Field, "rules[0]") {
+		t.Errorf("Validate(FilterSettings) returned %v, want only the rule", err)
+	}
+
+	config.Rules = nil
+	if err := config.Validate(FilterSettings); err != nil {
+		t.Errorf("Validate(FilterSettings) returned %v without a host or download directory", err)
+	}
+}
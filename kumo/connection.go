@@ -1,62 +1,252 @@
 package kumo
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/sww/kumo/nntp"
 )
 
+const (
+	maxRedialDelay = time.Minute
+	// How many times to redial a lost connection before giving up on it.
+	maxRedials = 8
+	// How long to wait for a free connection before giving up on a tier.
+	connectionWait = 5 * time.Minute
+)
+
 type Connection struct {
 	group  string
+	server Server
 	client *nntp.NNTP
 }
 
+// A ConnectionPool holds the connections for every server in one priority
+// tier.
 type ConnectionPool struct {
 	size        int
+	priority    int
 	connections chan Connection
+	queue       chan *request
+	mu          sync.Mutex
+	open        []Connection
+	// live counts the connections that haven't been given up on. When it
+	// reaches zero, dead is closed and err says why.
+	live int
+	dead chan struct{}
+	err  error
+}
+
+type ConnectionStats struct {
+	Server string
+	nntp.Stats
 }
 
-func InitConnectionPool(server, username, password string, port, connections int, ssl bool) (*ConnectionPool, error) {
+func InitConnectionPool(servers []Server) (*ConnectionPool, error) {
+	total := 0
+	for _, server := range servers {
+		total += server.Connections
+	}
+
 	pool := &ConnectionPool{
-		connections: make(chan Connection, connections),
-		size:        connections,
+		connections: make(chan Connection, total),
+		queue:       make(chan *request),
+		size:        total,
+	}
+	if len(servers) > 0 {
+		pool.priority = servers[0].Priority
 	}
 
 	wait := new(sync.WaitGroup)
-	wait.Add(connections)
+	wait.Add(total)
+
+	for _, server := range servers {
+		for i := 0; i < server.Connections; i++ {
+			go func(server Server) {
+				defer wait.Done()
 
-	for i := 0; i < connections; i++ {
-		go func() {
-			defer wait.Done()
+				connection, err := dial(server)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+
+				pool.track(*connection)
+				pool.connections <- *connection
+			}(server)
+		}
+	}
+
+	wait.Wait()
+
+	if len(pool.connections) < 1 {
+		return nil, errors.New("no connections available")
+	}
 
-			connection := new(Connection)
-			client, err := nntp.New("tcp", fmt.Sprintf("%v:%v", server, port), ssl)
-			if err != nil {
-				log.Printf("Error Connecting to \"%v\"", server)
+	pool.size = len(pool.connections)
+	pool.live = pool.size
+	pool.dead = make(chan struct{})
+
+	return pool, nil
+}
+
+func dial(server Server) (*Connection, error) {
+	client, err := nntp.New("tcp", fmt.Sprintf("%v:%v", server.Host, server.Port), server.security(), &server.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %q: %w", server.Host, err)
+	}
+
+	if _, err := client.Auth(server.Username, server.Password); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("problem authenticating with %q: %w", server.Host, err)
+	}
+
+	if server.Compress {
+		if err := client.Compress(); err == nntp.ErrCompressionUnsupported {
+			log.Printf("\"%v\" does not support compression", server.Host)
+		} else if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("problem enabling compression with %q: %w", server.Host, err)
+		}
+	}
+
+	return &Connection{group: "", server: server, client: client}, nil
+}
+
+// Returns how many commands may be in flight on the connection at once.
+func (c *Connection) pipelineDepth() int {
+	if c.server.Pipeline < 1 || !c.client.Capabilities().Pipelining() {
+		return 1
+	}
+
+	return c.server.Pipeline
+}
+
+func (p *ConnectionPool) track(connection Connection) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.open = append(p.open, connection)
+}
+
+func (p *ConnectionPool) untrack(connection Connection) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, c := range p.open {
+		if c.client == connection.client {
+			p.open = append(p.open[:i], p.open[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns the compression stats of every open connection.
+func (p *ConnectionPool) stats() []ConnectionStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var stats []ConnectionStats
+	for _, connection := range p.open {
+		stats = append(stats, ConnectionStats{connection.server.Host, connection.client.Stats()})
+	}
+
+	return stats
+}
+
+// Closes connection and dials a new one to the same server in the
+// background, backing off between failed attempts. It gives up after
+// maxRedials attempts or on an error that retrying won't fix.
+func (p *ConnectionPool) replace(connection Connection) {
+	p.untrack(connection)
+	connection.client.Close()
+
+	go func() {
+		delay := time.Second
+		for attempt := 1; ; attempt++ {
+			replacement, err := dial(connection.server)
+			if err == nil {
+				p.track(*replacement)
+				p.connections <- *replacement
 				return
 			}
 
-			msg, err := client.Auth(username, password)
-			if err != nil {
-				log.Printf("Problem authenticating, got msg: %v", msg)
+			if isPermanent(err) || attempt == maxRedials {
+				log.Printf("%v, giving up on the connection", err)
+				p.lose(err)
 				return
 			}
 
-			connection.group = ""
-			connection.client = client
+			log.Printf("%v, retrying in %v", err, delay)
+			time.Sleep(delay)
+			if delay *= 2; delay > maxRedialDelay {
+				delay = maxRedialDelay
+			}
+		}
+	}()
+}
+
+// Records that a connection is gone for good. Once every connection in the
+// tier is gone, the tier is dead.
+func (p *ConnectionPool) lose(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-			pool.connections <- *connection
-		}()
+	p.live--
+	if p.live == 0 {
+		p.err = fmt.Errorf("no connections left to priority %d servers: %w", p.priority, err)
+		close(p.dead)
 	}
+}
 
-	wait.Wait()
+// Takes a free connection, waiting up to connectionWait for one. It fails
+// straight away once the tier is dead.
+func (p *ConnectionPool) acquire() (Connection, error) {
+	select {
+	case connection := <-p.connections:
+		return connection, nil
+	default:
+	}
 
-	if len(pool.connections) < 1 {
-		return nil, errors.New("no connections available")
+	timer := time.NewTimer(connectionWait)
+	defer timer.Stop()
+
+	select {
+	case connection := <-p.connections:
+		return connection, nil
+	case <-p.dead:
+		return Connection{}, p.Err()
+	case <-timer.C:
+		return Connection{}, fmt.Errorf("no free connection to priority %d servers after %v", p.priority, connectionWait)
 	}
+}
 
-	return pool, nil
+// Returns why the tier is dead, or nil if it still has connections.
+func (p *ConnectionPool) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Returns whether dialing again can't fix err, like a rejected login or a
+// certificate that doesn't verify.
+func isPermanent(err error) bool {
+	var nntpErr *nntp.Error
+	if errors.As(err, &nntpErr) {
+		return !nntpErr.Temporary()
+	}
+
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.Is(err, nntp.ErrFingerprintMismatch) || errors.Is(err, nntp.ErrStartTLSUnsupported) ||
+		errors.As(err, &verifyErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
 }
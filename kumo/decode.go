@@ -1,37 +1,46 @@
 package kumo
 
 import (
+	"bytes"
 	"fmt"
 	"hash/crc32"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/sww/dumblog"
-	"github.com/sww/yenc"
 )
 
 type DecodedPart struct {
-	*yenc.Part
+	Name  string
+	Part  int
+	Total int
+	// Size is the size of the whole file the part belongs to.
+	Size int64
+	// BeginSize and EndSize are the 1-based offsets of the part's first and
+	// last bytes in the file, as in the =ypart line.
+	BeginSize   int64
+	EndSize     int64
+	CRC32       string
+	Body        []byte
 	SegmentName string
+	buffer      *bytes.Buffer
+	reserved    int64
 }
 
 type Decode struct {
 	JoinQueue chan *DecodedPart
 	Logger    *dumblog.DumbLog
+	Memory    *memoryBudget
 	Progress  *Progress
-	Queue     chan string
+	Queue     chan *Article
 	Stop      chan bool
-	TempPath  string
 	Wait      *sync.WaitGroup
 }
 
 func InitDecode(w *sync.WaitGroup) *Decode {
 	return &Decode{
-		Queue: make(chan string),
+		Queue: make(chan *Article),
 		Stop:  make(chan bool, 1),
 		Wait:  w,
 	}
@@ -43,56 +52,50 @@ func (d *Decode) Run() {
 		case <-d.Stop:
 			d.Logger.Print("[DECODE] Decode stopped")
 			return
-		case segment := <-d.Queue:
-			d.Logger.Printf("[DECODE] Decode got %v", segment)
-			go func(segment string) {
-				part, err := d.decode(segment)
+		case article := <-d.Queue:
+			d.Logger.Printf("[DECODE] Decode got %v", article.Segment.Segment)
+			go func(article *Article) {
+				size := int64(article.Body.Len())
+				part, err := d.decode(article)
 				if err != nil {
-					if fileStat, ok := os.Stat(segment); ok == nil {
-						d.Progress.addBroken(fileStat.Size())
-					}
+					d.Progress.addBroken(size)
 					d.Logger.Printf("[DECODE] Done() because of err: %v", err)
 					d.Wait.Done()
 				} else {
-					_, segmentName := filepath.Split(segment)
-					d.JoinQueue <- &DecodedPart{part, segmentName}
+					d.JoinQueue <- part
 				}
-			}(segment)
+			}(article)
 		default:
 			time.Sleep(100 * time.Millisecond)
 		}
 	}
 }
 
-func (d *Decode) decode(filename string) (*yenc.Part, error) {
-	file, err := os.Open(filename)
-	defer file.Close()
+// Decodes article into a pooled buffer, releasing the article's buffer.
+func (d *Decode) decode(article *Article) (*DecodedPart, error) {
+	decoded := getBuffer()
+	part, err := decodeYenc(article.Body.Bytes(), decoded)
 
-	if err != nil {
-		return nil, err
-	}
+	putBuffer(article.Body)
+	d.Memory.release(article.Segment.Bytes)
 
-	part, err := yenc.Decode(file)
 	if err != nil {
+		putBuffer(decoded)
+		d.Memory.release(article.reserved - article.Segment.Bytes)
 		return nil, err
 	}
 
-	defer os.Remove(filename)
-
-	d.Logger.Print("[DECODE] Writing decoded file ", part.Name)
+	part.SegmentName = article.Segment.Segment
+	part.buffer = decoded
+	part.reserved = article.reserved - article.Segment.Bytes
 
-	partFilename := filepath.Join(d.TempPath, fmt.Sprintf("%v.%v", part.Name, part.BeginPart))
-	ioutil.WriteFile(partFilename, part.Body, 0644)
+	if !checksum(part.Body, part.CRC32) {
+		d.Logger.Print("[DECODE] Checksums did not match")
+		d.Progress.addBroken(part.EndSize - part.BeginSize + 1)
+	}
 
 	d.Logger.Printf("[DECODE] Adding %v to JoinQueue", part.Name)
 
-	go func() {
-		if !checksum(part.Body, part.CRC32) {
-			d.Logger.Print("[DECODE] Checksums did not match")
-			d.Progress.addBroken(part.EndSize - part.BeginSize)
-		}
-	}()
-
 	return part, nil
 }
 
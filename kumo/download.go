@@ -1,41 +1,75 @@
 package kumo
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/sww/dumblog"
+	"github.com/sww/kumo/nntp"
 )
 
 type Download struct {
-	Stop           chan bool
-	Queue          chan Segment
-	ConnectionPool ConnectionPool
-	DecodeQueue    chan string
-	Logger         *dumblog.DumbLog
-	Progress       *Progress
-	Wait           *sync.WaitGroup
-	TempPath       string
-}
-
-func InitDownload(host, username, password string, port, connections int, ssl bool, w *sync.WaitGroup) (*Download, error) {
-	connectionPool, err := InitConnectionPool(host, username, password, port, connections, ssl)
-	if err != nil {
-		return nil, err
+	Stop            chan bool
+	Queue           chan Segment
+	ConnectionPools []*ConnectionPool
+	DecodeQueue     chan *Article
+	Logger          *dumblog.DumbLog
+	Memory          *memoryBudget
+	Progress        *Progress
+	Wait            *sync.WaitGroup
+	Retries         int
+	RetryDelay      time.Duration
+	start           sync.Once
+}
+
+// An Article is a downloaded segment, still yEnc encoded.
+type Article struct {
+	Segment  Segment
+	Body     *bytes.Buffer
+	reserved int64
+}
+
+// A request is a segment waiting on, or in flight in, a connection's
+// pipeline.
+type request struct {
+	segment Segment
+	attempt int
+	// Set when a GROUP command was sent ahead of this request's BODY.
+	group string
+	// Memory held for the segment's buffers until it is written out.
+	reserved int64
+}
+
+func InitDownload(servers []Server, w *sync.WaitGroup) (*Download, error) {
+	var pools []*ConnectionPool
+	for _, tier := range tiers(servers) {
+		pool, err := InitConnectionPool(tier)
+		if err != nil {
+			log.Printf("No connections for priority %v servers: %v", tier[0].Priority, err)
+			continue
+		}
+		pools = append(pools, pool)
+	}
+
+	if len(pools) < 1 {
+		return nil, errors.New("no connections available")
 	}
 
 	return &Download{
-		ConnectionPool: *connectionPool,
-		Queue:          make(chan Segment),
-		Stop:           make(chan bool, 1),
-		Wait:           w,
+		ConnectionPools: pools,
+		Queue:           make(chan Segment),
+		Stop:            make(chan bool, 1),
+		Wait:            w,
 	}, nil
 }
 
 func (d *Download) Run() {
+	d.start.Do(d.startWorkers)
+
 	for {
 		select {
 		case <-d.Stop:
@@ -43,62 +77,250 @@ func (d *Download) Run() {
 			return
 		case segment := <-d.Queue:
 			d.Logger.Print("[DOWNLOAD] Run() got segment ", segment)
-			go func(segment Segment) {
-				defer d.Progress.Add(segment.Bytes)
+			d.ConnectionPools[0].queue <- &request{segment: segment}
+		default:
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+// Starts one worker per connection. A worker takes a connection from its
+// tier when there is work, keeps its pipeline full from the tier's queue and
+// hands the connection back once the queue runs dry.
+func (d *Download) startWorkers() {
+	for i, pool := range d.ConnectionPools {
+		var next *ConnectionPool
+		if i+1 < len(d.ConnectionPools) {
+			next = d.ConnectionPools[i+1]
+		}
+
+		for j := 0; j < pool.size; j++ {
+			go d.work(pool, next)
+		}
+	}
+}
+
+func (d *Download) work(pool, next *ConnectionPool) {
+	for {
+		r := <-pool.queue
+		connection, err := pool.acquire()
+		if err != nil {
+			d.abandon(pool, next, r, err)
+			continue
+		}
 
-				connection := <-d.ConnectionPool.connections
-				segmentName, err := d.download(segment.Segment, segment.Group, &connection)
+		d.drain(pool, next, connection, r)
+	}
+}
 
-				if err != nil {
-					d.Progress.addBroken(segment.Bytes)
-					d.Logger.Printf("[DOWNLOAD] Done() because of err: %v", err)
-					d.Wait.Done()
-					return
+// Pipelines requests down connection, starting with waiting, until nothing
+// is queued or in flight, then returns the connection to the pool.
+func (d *Download) drain(pool, next *ConnectionPool, connection Connection, waiting *request) {
+	var inflight []*request
+
+	for {
+		depth := connection.pipelineDepth()
+		for len(inflight) < depth {
+			r := waiting
+			waiting = nil
+			if r == nil {
+				select {
+				case r = <-pool.queue:
+				default:
 				}
+			}
+			if r == nil {
+				break
+			}
 
-				d.DecodeQueue <- segmentName
-			}(segment)
-		default:
-			time.Sleep(100 * time.Millisecond)
+			// Only block on memory when nothing is in flight, otherwise
+			// read responses to free some up.
+			if !d.reserve(r, len(inflight) == 0) {
+				waiting = r
+				break
+			}
+
+			inflight = append(inflight, r)
+			if err := d.send(&connection, r); err != nil {
+				d.dropConnection(pool, connection, inflight, nil, err)
+				return
+			}
+		}
+
+		if len(inflight) == 0 {
+			pool.connections <- connection
+			return
+		}
+
+		r := inflight[0]
+		inflight = inflight[1:]
+
+		article, err := d.receive(&connection, r)
+		if isDead(err) {
+			d.dropConnection(pool, connection, inflight, r, err)
+			return
 		}
+
+		d.finish(pool, next, r, article, err)
 	}
 }
 
-func group(group string, connection *Connection) error {
-	_, err := connection.client.Group(group)
-	if err != nil {
-		return err
+// Delay before the first retry when RetryDelay is unset, so retries back
+// off instead of hammering a failing server.
+const defaultRetryDelay = time.Second
+
+// Size assumed for a segment whose NZB doesn't give one, on the large side
+// of what posting tools produce.
+const defaultArticleSize = int64(1 * MB)
+
+// Reserves memory for r's encoded and decoded buffers.
+func (d *Download) reserve(r *request, block bool) bool {
+	size := 2 * r.segment.Bytes
+	if size == 0 {
+		size = 2 * defaultArticleSize
+	}
+	if block {
+		d.Memory.acquire(size)
+	} else if !d.Memory.tryAcquire(size) {
+		return false
 	}
 
-	connection.group = group
+	r.reserved = size
+	return true
+}
 
-	return nil
+func (d *Download) unreserve(r *request) {
+	d.Memory.release(r.reserved)
+	r.reserved = 0
 }
 
-func (d *Download) download(segmentName, segmentGroup string, connection *Connection) (string, error) {
-	defer func() { d.ConnectionPool.connections <- *connection }()
+// Sends the commands for r, switching group first if needed.
+func (d *Download) send(connection *Connection, r *request) error {
+	r.group = ""
+	if r.segment.Group != connection.group {
+		d.Logger.Printf("[DOWNLOAD] Switching from group '%v' to '%v'", connection.group, r.segment.Group)
+		if err := connection.client.SendGroup(r.segment.Group); err != nil {
+			return err
+		}
+		r.group = r.segment.Group
+		connection.group = r.segment.Group
+	}
+
+	d.Logger.Printf("[DOWNLOAD] send(%v)", r.segment.Segment)
+
+	return connection.client.SendBody(fmt.Sprintf("<%s>", r.segment.Segment))
+}
 
-	d.Logger.Printf("[DOWNLOAD] download(%v)", segmentName)
-	if segmentGroup != connection.group {
-		d.Logger.Printf("[DOWNLOAD] Switching from group '%v' to '%v'", connection.group, segmentGroup)
-		// TODO: Handle error.
-		group(segmentGroup, connection)
+// Reads the responses for r into a pooled buffer.
+func (d *Download) receive(connection *Connection, r *request) (*Article, error) {
+	if r.group != "" {
+		if _, err := connection.client.ReadGroup(); err != nil {
+			if isDead(err) {
+				return nil, err
+			}
+			// Articles are fetched by message-id, so carry on without it.
+			d.Logger.Printf("[DOWNLOAD] Switching to group '%v' failed: %v", r.group, err)
+			connection.group = ""
+		}
 	}
 
-	_, _, resp, err := connection.client.Body(fmt.Sprintf("<%s>", segmentName))
+	_, _, resp, err := connection.client.ReadBody()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	body := getBuffer()
+	if _, err := body.ReadFrom(resp); err != nil {
+		putBuffer(body)
+		return nil, err
 	}
 
-	msg, err := ioutil.ReadAll(resp)
+	d.Logger.Printf("[DOWNLOAD] receive() read %v bytes of %v", body.Len(), r.segment.Segment)
+
+	return &Article{Segment: r.segment, Body: body, reserved: r.reserved}, nil
+}
+
+// Replaces a dead connection, retrying failed and putting the rest of the
+// requests that were in flight back on the queue.
+func (d *Download) dropConnection(pool *ConnectionPool, connection Connection, inflight []*request, failed *request, err error) {
+	d.Logger.Printf("[DOWNLOAD] Replacing connection to %v after err: %v", connection.server.Host, err)
+	pool.replace(connection)
+
+	for _, r := range inflight {
+		d.unreserve(r)
+		go func(r *request) { pool.queue <- r }(r)
+	}
+
+	if failed != nil {
+		d.retry(pool, failed, err)
+	}
+}
+
+// Decides what happens to r once its response has been read. Missing
+// articles move on to the next priority tier, transient errors are retried
+// on the same tier with backoff, and anything else marks the segment broken.
+func (d *Download) finish(pool, next *ConnectionPool, r *request, article *Article, err error) {
 	if err != nil {
-		return "", err
+		d.unreserve(r)
 	}
 
-	fullSegment := filepath.Join(d.TempPath, segmentName)
-	ioutil.WriteFile(fullSegment, msg, 0644)
+	switch {
+	case err == nil:
+		d.Progress.Add(r.segment.Bytes)
+		d.DecodeQueue <- article
+	case errors.Is(err, nntp.ErrNoSuchArticle) && next != nil:
+		d.Logger.Printf("[DOWNLOAD] %v missing on priority %v servers", r.segment.Segment, pool.priority)
+		go func() { next.queue <- &request{segment: r.segment} }()
+	case isTransient(err):
+		d.retry(pool, r, err)
+	default:
+		d.broken(r, err)
+	}
+}
 
-	d.Logger.Printf("[DOWNLOAD] download() wrote '%v'", fullSegment)
+// Gives up on r when no connection in pool could be had for it, moving it
+// to the next tier or marking it broken.
+func (d *Download) abandon(pool, next *ConnectionPool, r *request, err error) {
+	if next != nil {
+		d.Logger.Printf("[DOWNLOAD] Moving %v off priority %v servers: %v", r.segment.Segment, pool.priority, err)
+		go func() { next.queue <- &request{segment: r.segment} }()
+		return
+	}
+
+	d.broken(r, err)
+}
+
+func (d *Download) retry(pool *ConnectionPool, r *request, err error) {
+	d.unreserve(r)
+	if r.attempt >= d.Retries {
+		d.broken(r, err)
+		return
+	}
+
+	delay := d.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	delay <<= uint(r.attempt)
+	r.attempt++
+	d.Logger.Printf("[DOWNLOAD] Retrying %v in %v after err: %v", r.segment.Segment, delay, err)
+	time.AfterFunc(delay, func() { pool.queue <- r })
+}
+
+func (d *Download) broken(r *request, err error) {
+	d.Progress.Add(r.segment.Bytes)
+	d.Progress.addBroken(r.segment.Bytes)
+	d.Logger.Printf("[DOWNLOAD] Done() because of err: %v", err)
+	d.Wait.Done()
+}
+
+// Returns whether the connection that returned err should be replaced.
+func isDead(err error) bool {
+	return nntp.IsConnError(err) || errors.Is(err, nntp.ErrServiceUnavailable) || errors.Is(err, nntp.ErrAuthRequired)
+}
 
-	return fullSegment, nil
+// Returns whether downloading the same segment again might succeed.
+func isTransient(err error) bool {
+	var nntpErr *nntp.Error
+	return isDead(err) || (errors.As(err, &nntpErr) && nntpErr.Temporary())
 }
@@ -0,0 +1,278 @@
+package kumo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sww/dumblog"
+	"github.com/sww/kumo/nntp"
+)
+
+func Test_isTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+		dead      bool
+	}{
+		{&nntp.ConnError{Err: io.ErrUnexpectedEOF}, true, true},
+		{&nntp.Error{Code: 400, Msg: "closing"}, true, true},
+		{&nntp.Error{Code: 480, Msg: "auth required"}, true, true},
+		{&nntp.Error{Code: 403, Msg: "internal fault"}, true, false},
+		{&nntp.Error{Code: 430, Msg: "no such article"}, false, false},
+		{&nntp.Error{Code: 481, Msg: "auth rejected"}, false, false},
+	}
+
+	for _, test := range tests {
+		if got := isTransient(test.err); got != test.transient {
+			t.Errorf("isTransient(%v) = %v, want %v", test.err, got, test.transient)
+		}
+		if got := isDead(test.err); got != test.dead {
+			t.Errorf("isDead(%v) = %v, want %v", test.err, got, test.dead)
+		}
+	}
+}
+
+func Test_isPermanent(t *testing.T) {
+	tests := []struct {
+		err       error
+		permanent bool
+	}{
+		{&nntp.ConnError{Err: io.ErrUnexpectedEOF}, false},
+		{&nntp.Error{Code: 400, Msg: "closing"}, false},
+		{fmt.Errorf("problem authenticating: %w", &nntp.Error{Code: 481, Msg: "auth rejected"}), true},
+		{&nntp.Error{Code: 502, Msg: "access denied"}, true},
+		{&nntp.ConnError{Err: fmt.Errorf("%w: got ab", nntp.ErrFingerprintMismatch)}, true},
+	}
+
+	for _, test := range tests {
+		if got := isPermanent(test.err); got != test.permanent {
+			t.Errorf("isPermanent(%v) = %v, want %v", test.err, got, test.permanent)
+		}
+	}
+}
+
+func TestReplaceGivesUp(t *testing.T) {
+	server := Server{Host: "127.0.0.1", Port: fakeServer(t, nil), Connections: 1}
+	pool, err := InitConnectionPool([]Server{server})
+	if err != nil {
+		t.Fatalf("InitConnectionPool() returned %v", err)
+	}
+
+	connection := <-pool.connections
+	connection.server.Username = "user"
+	connection.server.Password = "bad"
+	pool.replace(connection)
+
+	select {
+	case <-pool.dead:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the pool to die")
+	}
+	if err := pool.Err(); !errors.Is(err, nntp.ErrAuthRejected) {
+		t.Errorf("Err() = %v, want %v", err, nntp.ErrAuthRejected)
+	}
+}
+
+func TestReserveUnknownSize(t *testing.T) {
+	download := &Download{Memory: newMemoryBudget(4 * defaultArticleSize)}
+	r := &request{segment: Segment{Segment: "1@foo"}}
+	if !download.reserve(r, false) {
+		t.Fatal("reserve() = false, want true")
+	}
+	if r.reserved != 2*defaultArticleSize {
+		t.Errorf("Reserved %d bytes, want %d", r.reserved, 2*defaultArticleSize)
+	}
+}
+
+// Starts a fake NNTP server holding articles, keyed by message-id without
+// angle brackets, and returns its port.
+func fakeServer(t *testing.T, articles map[string]string) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				tp := textproto.NewConn(conn)
+				tp.PrintfLine("200 Welcome")
+				for {
+					line, err := tp.ReadLine()
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					switch strings.ToUpper(fields[0]) {
+					case "CAPABILITIES":
+						tp.PrintfLine("101 Capability list:\r\nVERSION 2\r\nREADER\r\n.")
+					case "AUTHINFO":
+						if strings.EqualFold(fields[1], "user") {
+							tp.PrintfLine("381 Password required")
+						} else if len(fields) > 2 && fields[2] == "bad" {
+							tp.PrintfLine("481 Authentication failed")
+						} else {
+							tp.PrintfLine("281 Ok")
+						}
+					case "GROUP":
+						tp.PrintfLine("211 1 1 1 %s", fields[1])
+					case "STAT":
+						if _, ok := articles[strings.Trim(fields[1], "<>")]; ok {
+							tp.PrintfLine("223 0 %s", fields[1])
+						} else {
+							tp.PrintfLine("430 No Such Article")
+						}
+					case "BODY":
+						body, ok := articles[strings.Trim(fields[1], "<>")]
+						if !ok {
+							tp.PrintfLine("430 No Such Article")
+							continue
+						}
+						tp.PrintfLine("222 0 %s", fields[1])
+						w := tp.DotWriter()
+						w.Write([]byte(body))
+						w.Close()
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestDownloadTiers(t *testing.T) {
+	primary := fakeServer(t, map[string]string{"1@foo": "one\r\n", "2@foo": "two\r\n"})
+	fill := fakeServer(t, map[string]string{"3@foo": "three\r\n"})
+
+	var wait sync.WaitGroup
+	download, err := InitDownload([]Server{
+		{Host: "127.0.0.1", Port: fill, Connections: 1, Priority: 1},
+		{Host: "127.0.0.1", Port: primary, Connections: 2, Pipeline: 2},
+	}, &wait)
+	if err != nil {
+		t.Fatalf("InitDownload() returned %v", err)
+	}
+
+	download.DecodeQueue = make(chan *Article)
+	download.Memory = newMemoryBudget(15)
+	download.Logger = &dumblog.DumbLog{Debug: false}
+	download.Progress = NewProgress()
+	go download.Run()
+
+	wait.Add(4)
+	go func() {
+		for _, id := range []string{"1@foo", "2@foo", "3@foo", "4@foo"} {
+			download.Queue <- Segment{Segment: id, Group: "alt.binaries.test", Bytes: 10}
+		}
+	}()
+
+	got := make(map[string]string)
+	done := make(chan bool)
+	go func() {
+		wait.Wait()
+		close(done)
+	}()
+
+	for len(got) < 3 {
+		select {
+		case article := <-download.DecodeQueue:
+			got[article.Segment.Segment] = article.Body.String()
+			download.Memory.release(article.reserved)
+			wait.Done()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out with %v downloaded", got)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the missing segment")
+	}
+
+	want := map[string]string{"1@foo": "one\n", "2@foo": "two\n", "3@foo": "three\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Downloaded %v, want %v", got, want)
+	}
+	if download.Progress.brokenSegments != 1 {
+		t.Errorf("Returned %d broken segments, want 1", download.Progress.brokenSegments)
+	}
+
+	// The workers are idle, so checking must get connections.
+	stat := make(chan map[string]bool)
+	go func() {
+		missing, err := download.stat([]string{"1@foo", "3@foo", "4@foo"})
+		if err != nil {
+			t.Errorf("stat() returned %v", err)
+		}
+		stat <- missing
+	}()
+
+	select {
+	case missing := <-stat:
+		if want := map[string]bool{"4@foo": true}; !reflect.DeepEqual(missing, want) {
+			t.Errorf("stat() = %v, want %v", missing, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out checking after downloading")
+	}
+}
+
+func TestDownloadDeadTier(t *testing.T) {
+	dead := make(chan struct{})
+	close(dead)
+	pool := &ConnectionPool{
+		size:        1,
+		connections: make(chan Connection),
+		queue:       make(chan *request),
+		dead:        dead,
+		err:         errors.New("no connections left"),
+	}
+
+	var wait sync.WaitGroup
+	download := &Download{
+		ConnectionPools: []*ConnectionPool{pool},
+		Queue:           make(chan Segment),
+		Stop:            make(chan bool, 1),
+		Wait:            &wait,
+		Memory:          newMemoryBudget(100),
+		Logger:          &dumblog.DumbLog{Debug: false},
+		Progress:        NewProgress(),
+	}
+	go download.Run()
+	defer func() { download.Stop <- true }()
+
+	wait.Add(2)
+	download.Queue <- Segment{Segment: "1@foo", Bytes: 10}
+	download.Queue <- Segment{Segment: "2@foo", Bytes: 10}
+
+	done := make(chan bool)
+	go func() {
+		wait.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting on a dead tier")
+	}
+	if download.Progress.brokenSegments != 2 {
+		t.Errorf("Returned %d broken segments, want 2", download.Progress.brokenSegments)
+	}
+}
@@ -0,0 +1,119 @@
+package kumo
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+)
+
+const nzbDoctype = `<!DOCTYPE nzb PUBLIC "-//newzBin//DTD NZB 1.1//EN" "http://www.newzbin.com/DTD/nzb/nzb-1.1.dtd">`
+
+type nzbXML struct {
+	XMLName xml.Name `xml:"http://www.newzbin.com/DTD/2003/nzb nzb"`
+	Meta    []Meta   `xml:"head>meta"`
+	Files   []File   `xml:"file"`
+}
+
+// Write writes the NZB as XML that Parse reads back the same.
+func (n *NZB) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header+nzbDoctype+"\n"); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(nzbXML{Meta: n.Meta, Files: n.Files}); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Merge combines NZBs for the same release into one. Files with the same
+// subject are merged into one file with the segments of both, and repeated
+// metadata is dropped.
+func Merge(nzbs ...*NZB) *NZB {
+	merged := new(NZB)
+	metas := make(map[Meta]bool)
+	files := make(map[string]int)
+
+	for _, nzb := range nzbs {
+		for _, meta := range nzb.Meta {
+			if !metas[meta] {
+				metas[meta] = true
+				merged.Meta = append(merged.Meta, meta)
+			}
+		}
+
+		for _, file := range nzb.Files {
+			i, ok := files[file.Subject]
+			if !ok {
+				files[file.Subject] = len(merged.Files)
+				file.Segments = append([]Segment(nil), file.Segments...)
+				merged.Files = append(merged.Files, file)
+				continue
+			}
+			merged.Files[i] = mergeFile(merged.Files[i], file)
+		}
+	}
+
+	return merged
+}
+
+// Adds the groups and segments of b that a doesn't have to a.
+func mergeFile(a, b File) File {
+	groups := make(map[string]bool)
+	for _, group := range a.Groups {
+		groups[group] = true
+	}
+	for _, group := range b.Groups {
+		if !groups[group] {
+			groups[group] = true
+			a.Groups = append(a.Groups, group)
+		}
+	}
+
+	numbers := make(map[int]bool)
+	for _, segment := range a.Segments {
+		numbers[segment.Number] = true
+	}
+	for _, segment := range b.Segments {
+		if !numbers[segment.Number] {
+			numbers[segment.Number] = true
+			a.Segments = append(a.Segments, segment)
+		}
+	}
+	sort.SliceStable(a.Segments, func(i, j int) bool { return a.Segments[i].Number < a.Segments[j].Number })
+
+	return a
+}
+
+// Subset returns an NZB with the files for which keep returns true.
+func (n *NZB) Subset(keep func(File) bool) *NZB {
+	subset := &NZB{Meta: n.Meta}
+	for _, file := range n.Files {
+		if keep(file) {
+			subset.Files = append(subset.Files, file)
+		}
+	}
+
+	return subset
+}
+
+// Sets splits the NZB by the collection names in its subjects, returning
+// the names in the order they first appear.
+func (n *NZB) Sets() ([]string, map[string]*NZB) {
+	var names []string
+	sets := make(map[string]*NZB)
+	for _, file := range n.Files {
+		name := file.ParsedSubject().Name
+		if sets[name] == nil {
+			names = append(names, name)
+			sets[name] = &NZB{Meta: n.Meta}
+		}
+		sets[name].Files = append(sets[name].Files, file)
+	}
+
+	return names, sets
+}
@@ -0,0 +1,76 @@
+package kumo
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+	nzb := &NZB{
+		Meta: []Meta{{Type: "title", Value: "Some <Title> & more"}, {Type: "password", Value: "secret"}},
+		Files: []File{
+			{Poster: "poster", Subject: `Show [1/2] - "show.rar" yEnc (1/2)`, Date: 123, Groups: []string{"alt.a", "alt.b"}, Segments: []Segment{{Bytes: 11, Number: 1, Segment: "1@foo.com"}, {Bytes: 12, Number: 2, Segment: "2@foo.com"}}},
+			{Poster: "poster", Subject: `Show [2/2] - "show.par2" yEnc (1/1)`, Date: 124, Groups: []string{"alt.a"}, Segments: []Segment{{Bytes: 13, Number: 1, Segment: "3@foo.com"}}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := nzb.Write(&buf); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	parsed, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, nzb) {
+		t.Errorf("Returned %+v, want %+v", parsed, nzb)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := &NZB{
+		Meta:  []Meta{{Type: "title", Value: "Show"}},
+		Files: []File{{Subject: "one", Groups: []string{"alt.a"}, Segments: []Segment{{Number: 1, Segment: "1@a"}, {Number: 3, Segment: "3@a"}}}},
+	}
+	b := &NZB{
+		Meta: []Meta{{Type: "title", Value: "Show"}, {Type: "tag", Value: "HD"}},
+		Files: []File{
+			{Subject: "one", Groups: []string{"alt.b"}, Segments: []Segment{{Number: 2, Segment: "2@b"}, {Number: 3, Segment: "3@b"}}},
+			{Subject: "two", Segments: []Segment{{Number: 1, Segment: "1@two"}}},
+		},
+	}
+
+	merged := Merge(a, b)
+
+	want := &NZB{
+		Meta: []Meta{{Type: "title", Value: "Show"}, {Type: "tag", Value: "HD"}},
+		Files: []File{
+			{Subject: "one", Groups: []string{"alt.a", "alt.b"}, Segments: []Segment{{Number: 1, Segment: "1@a"}, {Number: 2, Segment: "2@b"}, {Number: 3, Segment: "3@a"}}},
+			{Subject: "two", Segments: []Segment{{Number: 1, Segment: "1@two"}}},
+		},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("Returned %+v, want %+v", merged, want)
+	}
+	if len(a.Files[0].Segments) != 2 {
+		t.Errorf("Merge() changed its input, %+v", a.Files[0])
+	}
+}
+
+func TestSets(t *testing.T) {
+	nzb := &NZB{Files: []File{
+		{Subject: `Show [1/2] - "show.rar" yEnc (1/1)`},
+		{Subject: `Other [1/1] - "other.mkv" yEnc (1/1)`},
+		{Subject: `Show [2/2] - "show.par2" yEnc (1/1)`},
+	}}
+
+	names, sets := nzb.Sets()
+	if !reflect.DeepEqual(names, []string{"Show", "Other"}) {
+		t.Fatalf("Returned names %q", names)
+	}
+	if len(sets["Show"].Files) != 2 || len(sets["Other"].Files) != 1 {
+		t.Errorf("Returned sets %+v", sets)
+	}
+}
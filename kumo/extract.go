@@ -0,0 +1,314 @@
+package kumo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Binaries used to extract RAR sets and 7z archives when Config.Unpacker
+// and Config.SevenZip are unset.
+const (
+	defaultUnpacker = "unrar"
+	defaultSevenZip = "7z"
+)
+
+var (
+	ErrWrongPassword  = errors.New("wrong password")
+	ErrCorruptArchive = errors.New("corrupt archive")
+	ErrMissingVolume  = errors.New("missing volume")
+
+	rarPartRe = regexp.MustCompile(`(?i)^(.*)\.part(\d+)\.rar$`)
+	rarOldRe  = regexp.MustCompile(`(?i)^(.*)\.(rar|r\d{2,3})$`)
+	splitRe   = regexp.MustCompile(`^(.*)\.(\d{3})$`)
+	// Passwords can be given in the NZB's name, as in name{{password}}.nzb.
+	nzbPasswordRe = regexp.MustCompile(`^(.*)\{\{(.+)\}\}$`)
+	// What unrar before 5.0 and 7z say about a wrong password.
+	unrarPasswordRe    = regexp.MustCompile(`The specified password is incorrect`)
+	sevenZipPasswordRe = regexp.MustCompile(`Wrong password`)
+)
+
+// ExtractError is an archive that failed to extract.
+type ExtractError struct {
+	Archive string
+	Err     error
+}
+
+func (e *ExtractError) Error() string {
+	return fmt.Sprintf("extracting %s: %v", e.Archive, e.Err)
+}
+
+func (e *ExtractError) Unwrap() error {
+	return e.Err
+}
+
+type archiveKind int
+
+const (
+	kindRAR archiveKind = iota
+	kindZip
+	kind7z
+	kindSplit
+)
+
+// An archive is a set of volumes that is extracted from its first volume.
+type archive struct {
+	Kind    archiveKind
+	First   string
+	Volumes []string
+}
+
+// Finds the archives in dir. RAR sets are named either name.partNN.rar, or
+// name.rar followed by name.r00, name.r01 and so on. Split files, named
+// name.001, name.002 and so on, are only found by splitSets.
+func findArchives(dir string) ([]archive, error) {
+	return scanArchives(dir, func(name string) (string, archiveKind, bool, bool) {
+		if matches := rarPartRe.FindStringSubmatch(name); matches != nil {
+			n, _ := strconv.Atoi(matches[2])
+			return "part:" + strings.ToLower(matches[1]), kindRAR, n == 1, true
+		}
+		if matches := rarOldRe.FindStringSubmatch(name); matches != nil {
+			return "old:" + strings.ToLower(matches[1]), kindRAR, strings.EqualFold(matches[2], "rar"), true
+		}
+
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".zip":
+			return "zip:" + strings.ToLower(name), kindZip, true, true
+		case ".7z":
+			return "7z:" + strings.ToLower(name), kind7z, true, true
+		}
+
+		return "", 0, false, false
+	})
+}
+
+// Finds the sets of split files in dir, named name.001, name.002 and so on.
+func splitSets(dir string) ([]archive, error) {
+	return scanArchives(dir, func(name string) (string, archiveKind, bool, bool) {
+		matches := splitRe.FindStringSubmatch(name)
+		if matches == nil {
+			return "", 0, false, false
+		}
+
+		n, _ := strconv.Atoi(matches[2])
+		return "split:" + strings.ToLower(matches[1]), kindSplit, n == 1, true
+	})
+}
+
+// Groups the files in dir into archives with match, which returns the key
+// of the archive a file belongs to, its kind, whether the file is the first
+// volume and whether it belongs to an archive at all.
+func scanArchives(dir string, match func(name string) (string, archiveKind, bool, bool)) ([]archive, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make(map[string]*archive)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		key, kind, first, ok := match(name)
+		if !ok {
+			continue
+		}
+
+		set := sets[key]
+		if set == nil {
+			set = &archive{Kind: kind}
+			sets[key] = set
+		}
+
+		path := filepath.Join(dir, name)
+		set.Volumes = append(set.Volumes, path)
+		if first {
+			set.First = path
+		}
+	}
+
+	var keys []string
+	for key := range sets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var archives []archive
+	for _, key := range keys {
+		archives = append(archives, *sets[key])
+	}
+
+	return archives, nil
+}
+
+// Splits a password given as name{{password}} off the name.
+func splitPassword(name string) (string, string) {
+	matches := nzbPasswordRe.FindStringSubmatch(name)
+	if matches == nil {
+		return name, ""
+	}
+
+	return matches[1], matches[2]
+}
+
+// Unpacker extracts archives with an external unrar or 7z binary.
+type Unpacker struct {
+	Command string
+}
+
+func (u *Unpacker) sevenZip() bool {
+	return strings.Contains(strings.ToLower(filepath.Base(u.Command)), "7z")
+}
+
+// Returns the arguments to extract archive into dest. Passwords are never
+// put on the command line, where other users could see them. The tools
+// prompt for one instead, and run answers on stdin.
+func (u *Unpacker) args(archive, dest, password string) []string {
+	if u.sevenZip() {
+		return []string{"x", "-y", "-o" + dest, archive}
+	}
+
+	args := []string{"x", "-o+", "-y"}
+	if password == "" {
+		// Stops unrar from asking for a password.
+		args = append(args, "-p-")
+	}
+
+	return append(args, archive, dest+string(filepath.Separator))
+}
+
+// Extract extracts archive into dest, first without a password and then
+// with each of passwords until one works.
+func (u *Unpacker) Extract(archive, dest string, passwords []string) error {
+	var err error
+	for _, password := range append([]string{""}, passwords...) {
+		err = u.run(archive, dest, password)
+		if err != ErrWrongPassword {
+			break
+		}
+	}
+
+	return err
+}
+
+func (u *Unpacker) run(archive, dest, password string) error {
+	cmd := exec.Command(u.Command, u.args(archive, dest, password)...)
+	cmd.Stdin = strings.NewReader(password + "\n")
+	// Without this, the prompt reads the terminal kumo runs in.
+	detach(cmd)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	// unrar exits with 11 for a wrong password since 5.0, 7z only says so.
+	if u.sevenZip() && sevenZipPasswordRe.Match(output) {
+		return ErrWrongPassword
+	}
+	if !u.sevenZip() && (exitErr.ExitCode() == 11 || unrarPasswordRe.Match(output)) {
+		return ErrWrongPassword
+	}
+
+	return fmt.Errorf("%w: %s", ErrCorruptArchive, lastLine(output))
+}
+
+func lastLine(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// Joins the split files in result.Path and extracts the archives in it into
+// it, recording those that fail.
+func (k *Kumo) extract(result *JobResult, passwords []string) {
+	// Split files are joined first, since they are often archives.
+	splits, err := splitSets(result.Path)
+	if err != nil {
+		k.logger.Printf("[EXTRACT] Error finding split files: %v", err)
+		return
+	}
+	for _, split := range splits {
+		k.logger.Printf("[EXTRACT] Joining %d split files of %v", len(split.Volumes), split.First)
+		k.finishArchive(result, split, joinSplit(split))
+	}
+
+	archives, err := findArchives(result.Path)
+	if err != nil {
+		k.logger.Printf("[EXTRACT] Error finding archives: %v", err)
+		return
+	}
+
+	unrar := &Unpacker{Command: k.config.Unpacker}
+	if unrar.Command == "" {
+		unrar.Command = defaultUnpacker
+	}
+	sevenZip := &Unpacker{Command: k.config.SevenZip}
+	if sevenZip.Command == "" {
+		sevenZip.Command = defaultSevenZip
+	}
+
+	for _, archive := range archives {
+		if archive.First == "" {
+			k.finishArchive(result, archive, fmt.Errorf("%w: first volume", ErrMissingVolume))
+			continue
+		}
+
+		k.logger.Printf("[EXTRACT] Extracting %v", archive.First)
+
+		var err error
+		switch archive.Kind {
+		case kindRAR:
+			err = unrar.Extract(archive.First, result.Path, passwords)
+		case kindZip:
+			err = extractZip(archive.First, result.Path)
+		case kind7z:
+			err = sevenZip.Extract(archive.First, result.Path, passwords)
+		}
+		k.finishArchive(result, archive, err)
+	}
+}
+
+// Records how extracting archive went, removing its volumes if it worked
+// and Config.Cleanup is set.
+func (k *Kumo) finishArchive(result *JobResult, archive archive, err error) {
+	name := filepath.Base(archive.First)
+	if archive.First == "" {
+		name = filepath.Base(archive.Volumes[0])
+	}
+
+	if err != nil {
+		err = &ExtractError{Archive: name, Err: err}
+		k.logger.Printf("[EXTRACT] %v", err)
+		result.ExtractErrors = append(result.ExtractErrors, err)
+		if !k.config.Quiet {
+			fmt.Printf("%s %v\n", red(PREFIX_COMPLETE_BROKEN), err)
+		}
+		return
+	}
+
+	result.Extracted = append(result.Extracted, name)
+	if !k.config.Quiet {
+		fmt.Printf("%s Extracted %s\n", green(PREFIX_COMPLETE_OK), name)
+	}
+
+	if k.config.Cleanup {
+		for _, volume := range archive.Volumes {
+			if err := os.Remove(volume); err != nil {
+				k.logger.Printf("[EXTRACT] Error removing %v: %v", volume, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package kumo
+
+import "os/exec"
+
+func detach(cmd *exec.Cmd) {}
@@ -0,0 +1,112 @@
+package kumo
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindArchives(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"movie.part01.rar", "movie.part02.rar", "movie.part03.rar",
+		"show.rar", "show.r00", "show.r01",
+		"extras.part2.rar",
+		"movie.nfo", "subs.zip", "movie.mkv.001",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+
+	archives, err := findArchives(dir)
+	if err != nil {
+		t.Fatalf("findArchives() returned %v", err)
+	}
+
+	join := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+		return paths
+	}
+	want := []archive{
+		{Kind: kindRAR, First: join("show.rar")[0], Volumes: join("show.r00", "show.r01", "show.rar")},
+		{Kind: kindRAR, First: "", Volumes: join("extras.part2.rar")},
+		{Kind: kindRAR, First: join("movie.part01.rar")[0], Volumes: join("movie.part01.rar", "movie.part02.rar", "movie.part03.rar")},
+		{Kind: kindZip, First: join("subs.zip")[0], Volumes: join("subs.zip")},
+	}
+	if !reflect.DeepEqual(archives, want) {
+		t.Errorf("findArchives() returned %+v, want %+v", archives, want)
+	}
+}
+
+func TestSplitPassword(t *testing.T) {
+	if name, password := splitPassword("movie{{secret}}"); name != "movie" || password != "secret" {
+		t.Errorf("splitPassword() returned %q, %q", name, password)
+	}
+	if name, password := splitPassword("movie"); name != "movie" || password != "" {
+		t.Errorf("splitPassword() returned %q, %q", name, password)
+	}
+}
+
+func TestUnpackerPasswords(t *testing.T) {
+	dir := t.TempDir()
+	// Stands in for unrar, accepting only the password "good" on stdin with
+	// no terminal to prompt on, and failing like a corrupt archive for
+	// "broken.rar" and "passwords.rar".
+	unrar := filepath.Join(dir, "unrar")
+	ioutil.WriteFile(unrar, []byte(`#!/bin/sh
+case "$*" in *broken.rar*) echo "Unexpected end of archive"; exit 3;; esac
+case "$*" in *passwords.rar*) echo "Cannot open passwords.txt"; exit 3;; esac
+case "$*" in *-p-*) echo "The specified password is incorrect."; exit 11;; esac
+case "$*" in *-p*) echo "Password on the command line"; exit 1;; esac
+# The real unrar prompts on the terminal when it can open one.
+(: </dev/tty) 2>/dev/null && { echo "Prompted on the terminal"; exit 1; }
+read password
+[ "$password" = "good" ] && exit 0
+echo "The specified password is incorrect."
+exit 11
+`), 0755)
+
+	unpacker := &Unpacker{Command: unrar}
+	if err := unpacker.Extract("a.rar", dir, []string{"bad", "good"}); err != nil {
+		t.Errorf("Extract() returned %v, want nil", err)
+	}
+	if err := unpacker.Extract("a.rar", dir, []string{"bad"}); err != ErrWrongPassword {
+		t.Errorf("Extract() returned %v, want ErrWrongPassword", err)
+	}
+	for _, archive := range []string{"broken.rar", "passwords.rar"} {
+		if err := unpacker.Extract(archive, dir, nil); !errors.Is(err, ErrCorruptArchive) {
+			t.Errorf("Extract(%q) returned %v, want ErrCorruptArchive", archive, err)
+		}
+	}
+}
+
+func TestUnpackerPasswords7z(t *testing.T) {
+	dir := t.TempDir()
+	// Stands in for 7z like the unrar stand-in above, saying "Wrong
+	// password" and failing with a data error for "broken.7z".
+	sevenZip := filepath.Join(dir, "7z")
+	ioutil.WriteFile(sevenZip, []byte(`#!/bin/sh
+case "$*" in *-p*) echo "Password on the command line"; exit 1;; esac
+case "$*" in *broken.7z*) echo "ERROR: Data Error : movie.mkv"; exit 2;; esac
+(: </dev/tty) 2>/dev/null && { echo "Prompted on the terminal"; exit 1; }
+read password
+[ "$password" = "good" ] && exit 0
+echo "ERROR: Wrong password : movie.mkv"
+exit 2
+`), 0755)
+
+	unpacker := &Unpacker{Command: sevenZip}
+	if err := unpacker.Extract("a.7z", dir, []string{"bad", "good"}); err != nil {
+		t.Errorf("Extract() returned %v, want nil", err)
+	}
+	if err := unpacker.Extract("a.7z", dir, []string{"bad"}); err != ErrWrongPassword {
+		t.Errorf("Extract() returned %v, want ErrWrongPassword", err)
+	}
+	if err := unpacker.Extract("broken.7z", dir, nil); !errors.Is(err, ErrCorruptArchive) {
+		t.Errorf("Extract() returned %v, want ErrCorruptArchive", err)
+	}
+}
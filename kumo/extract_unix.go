@@ -0,0 +1,14 @@
+//go:build unix
+
+package kumo
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Starts cmd in a new session, without a controlling terminal, so the
+// password prompts of unrar and 7z fall back from /dev/tty to stdin.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
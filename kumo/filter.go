@@ -1,50 +1,229 @@
 package kumo
 
 import (
+	"errors"
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/sww/dumblog"
 )
 
 type Filter struct {
-	Logger  *dumblog.DumbLog
-	regexps []*regexp.Regexp
+	Logger   *dumblog.DumbLog
+	regexps  []*regexp.Regexp
+	labels   []string
+	includes []*compiledRule
+	excludes []*compiledRule
+	now      func() time.Time
 }
 
-func NewFilter(regexps ...string) *Filter {
-	var s []*regexp.Regexp
-	for _, r := range regexps {
-		s = append(s, regexp.MustCompile(r))
+// RuleError is a filter rule that doesn't compile.
+type RuleError struct {
+	// Rule says where the rule is, as in filters[2] or rules[0].
+	Rule string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Rule, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// RuleErrors holds every rule that didn't compile.
+type RuleErrors []*RuleError
+
+func (e RuleErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Returns err as RuleErrors, or as a single error for source if it is
+// something else.
+func asRuleErrors(source string, err error) RuleErrors {
+	var errs RuleErrors
+	if errors.As(err, &errs) {
+		return errs
+	}
+
+	return RuleErrors{{Rule: source, Err: err}}
+}
+
+// NewFilter returns a filter that skips files with subjects matching any of
+// regexps, or the errors for those that don't compile.
+func NewFilter(regexps ...string) (*Filter, error) {
+	f := &Filter{now: time.Now}
+
+	var errs RuleErrors
+	for i, r := range regexps {
+		label := fmt.Sprintf("filters[%d] %q", i, r)
+		re, err := regexp.Compile(r)
+		if err != nil {
+			errs = append(errs, &RuleError{Rule: label, Err: err})
+			continue
+		}
+		f.regexps = append(f.regexps, re)
+		f.labels = append(f.labels, label)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return f, nil
+}
+
+// NewConfigFilter returns the filter for config's Filters, Rules and
+// FlagRules, or the errors for every one of them that doesn't compile.
+func NewConfigFilter(config *Config) (*Filter, error) {
+	var errs RuleErrors
+	f, err := NewFilter(config.Filters...)
+	if err != nil {
+		errs = append(errs, asRuleErrors("filters", err)...)
+		f = &Filter{now: time.Now}
+	}
+
+	if err := f.AddRules("rules", config.Rules...); err != nil {
+		errs = append(errs, asRuleErrors("rules", err)...)
+	}
+	errs = append(errs, f.addFlagRules(config.FlagRules)...)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return f, nil
+}
+
+// AddRules adds rules to the filter, naming them after source in errors, or
+// returns the errors for those that don't compile.
+func (f *Filter) AddRules(source string, rules ...Rule) error {
+	var errs RuleErrors
+	for i, rule := range rules {
+		if err := f.addRule(fmt.Sprintf("%s[%d] %v", source, i, rule), rule); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	return &Filter{regexps: s}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Adds rules given with -include and -exclude, naming each after its flag
+// and its position among the flags of the same kind, as in -include #2.
+func (f *Filter) addFlagRules(rules []Rule) RuleErrors {
+	var errs RuleErrors
+	counts := make(map[bool]int)
+	for _, rule := range rules {
+		counts[rule.Include]++
+		if err := f.addRule(strings.TrimSpace(FlagLabel(rule.Include, counts[rule.Include])+" "+rule.criteria()), rule); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// FlagLabel names the n'th -include or -exclude flag, counting from 1.
+func FlagLabel(include bool, n int) string {
+	if include {
+		return fmt.Sprintf("-include #%d", n)
+	}
+
+	return fmt.Sprintf("-exclude #%d", n)
+}
+
+func (f *Filter) addRule(label string, rule Rule) *RuleError {
+	compiled, err := rule.compile()
+	if err != nil {
+		return &RuleError{Rule: label, Err: err}
+	}
+
+	compiled.label = label
+	if rule.Include {
+		f.includes = append(f.includes, compiled)
+	} else {
+		f.excludes = append(f.excludes, compiled)
+	}
+
+	return nil
 }
 
 func (f *Filter) HasFilters() bool {
-	return len(f.regexps) > 0
+	return len(f.regexps) > 0 || len(f.includes) > 0 || len(f.excludes) > 0
 }
 
 // Returns whether or not the string s matches Filter.regexps.
 func (f *Filter) Filter(s string) bool {
-	for _, re := range f.regexps {
+	return f.filter(s) != ""
+}
+
+// Returns the label of the regexp s matches, or "".
+func (f *Filter) filter(s string) string {
+	for i, re := range f.regexps {
 		if re.MatchString(s) {
 			f.Logger.Printf("[FILTER] Regexp %v Matched %q", re, s)
-			return true
+			return f.labels[i]
+		}
+	}
+
+	return ""
+}
+
+// Keep returns whether file passes the filter's regexps and rules.
+func (f *Filter) Keep(file *File) bool {
+	keep, _ := f.Decide(file)
+	return keep
+}
+
+// Decide returns whether file passes the filter's regexps and rules, and
+// the rule that decided it, or "" if there was no rule to decide.
+func (f *Filter) Decide(file *File) (bool, string) {
+	if label := f.filter(file.Subject); label != "" {
+		return false, label
+	}
+
+	now := f.now()
+	for _, rule := range f.excludes {
+		if rule.matches(file, now) {
+			f.Logger.Printf("[FILTER] Rule %q Matched %q", rule.rule, file.Subject)
+			return false, rule.label
+		}
+	}
+
+	if len(f.includes) == 0 {
+		return true, ""
+	}
+	for _, rule := range f.includes {
+		if rule.matches(file, now) {
+			f.Logger.Printf("[FILTER] Rule %q Matched %q", rule.rule, file.Subject)
+			return true, rule.label
 		}
 	}
 
-	return false
+	f.Logger.Printf("[FILTER] No include rule Matched %q", file.Subject)
+	return false, ""
 }
 
 func (f *Filter) FilterNzb(nzb *NZB) *NZB {
-	if len(f.regexps) < 1 {
+	if !f.HasFilters() {
 		return nzb
 	}
 
-	var filteredNzb NZB
+	filteredNzb := NZB{Meta: nzb.Meta}
 	for _, file := range nzb.Files {
-		if !f.Filter(file.Subject) {
+		if f.Keep(&file) {
 			filteredNzb.Files = append(filteredNzb.Files, file)
 		}
 	}
@@ -53,8 +232,8 @@ func (f *Filter) FilterNzb(nzb *NZB) *NZB {
 }
 
 func (f *Filter) Split(nzb *NZB, extension string) []*NZB {
-	var filtered NZB
-	var unfiltered NZB
+	filtered := NZB{Meta: nzb.Meta}
+	unfiltered := NZB{Meta: nzb.Meta}
 	for _, file := range nzb.Files {
 		if strings.EqualFold(file.Extension(), extension) {
 			f.Logger.Printf("[FILTER] Filtered out extension %q from subject %q", extension, file.Subject)
@@ -1,13 +1,20 @@
 package kumo
 
 import (
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sww/dumblog"
 )
 
 func TestFilter(t *testing.T) {
-	filter := NewFilter("foo", "[a-c]+")
+	filter, err := NewFilter("foo", "[a-c]+")
+	if err != nil {
+		t.Fatalf("NewFilter() returned %v", err)
+	}
 	filter.Logger = &dumblog.DumbLog{Debug: false}
 
 	testStrings := []string{
@@ -32,3 +39,120 @@ func TestFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestFilterRules(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	day := 24 * 60 * 60
+	files := []File{
+		{Subject: `"movie.mkv" yEnc (1/2)`, Poster: "good@poster", Groups: []string{"alt.binaries.movies"}, Date: 1000000 - day, Segments: []Segment{{Bytes: 600 << 20}, {Bytes: 600 << 20}}},
+		{Subject: `"movie.srt" yEnc (1/1)`, Poster: "good@poster", Groups: []string{"alt.binaries.movies"}, Date: 1000000 - day, Segments: []Segment{{Bytes: 50 << 10}}},
+		{Subject: `"movie.nfo" yEnc (1/1)`, Poster: "good@poster", Groups: []string{"alt.binaries.movies"}, Date: 1000000 - day, Segments: []Segment{{Bytes: 1 << 10}}},
+		{Subject: `"spam.mkv" yEnc (1/1)`, Poster: "spammer", Groups: []string{"alt.binaries.movies"}, Date: 1000000 - day, Segments: []Segment{{Bytes: 700 << 20}}},
+		{Subject: `"old.mkv" yEnc (1/1)`, Poster: "good@poster", Groups: []string{"alt.binaries.movies"}, Date: 1000000 - 90*day, Segments: []Segment{{Bytes: 700 << 20}}},
+		{Subject: `"other.mkv" yEnc (1/1)`, Poster: "good@poster", Groups: []string{"alt.binaries.tv"}, Date: 1000000 - day, Segments: []Segment{{Bytes: 700 << 20}}},
+	}
+
+	var rules []Rule
+	for _, r := range []struct {
+		rule    string
+		include bool
+	}{
+		{"ext=.mkv minsize=1GB", true},
+		{"ext=srt", true},
+		{"poster=^spam", false},
+		{"maxage=30d group=movies", true},
+	} {
+		rule, err := ParseRule(r.rule, r.include)
+		if err != nil {
+			t.Fatalf("ParseRule(%q) returned %v", r.rule, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	filter, err := NewConfigFilter(&Config{Rules: rules})
+	if err != nil {
+		t.Fatalf("NewConfigFilter() returned %v", err)
+	}
+	filter.Logger = &dumblog.DumbLog{Debug: false}
+	filter.now = func() time.Time { return now }
+
+	// movie.nfo is kept by the last rule, which keeps anything recent in
+	// the movies group, while old.mkv is too old and other.mkv in the
+	// wrong group.
+	var kept []string
+	for _, file := range filter.FilterNzb(&NZB{Files: files}).Files {
+		kept = append(kept, file.ParsedSubject().Filename)
+	}
+	want := []string{"movie.mkv", "movie.srt", "movie.nfo"}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("Kept %q, want %q", kept, want)
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, rule := range []string{"", "ext", "color=red", "minsize=lots", "maxage=soon", "subject=("} {
+		if _, err := ParseRule(rule, true); err == nil {
+			t.Errorf("ParseRule(%q) returned no error", rule)
+		}
+	}
+}
+
+func TestNewConfigFilterErrors(t *testing.T) {
+	config := &Config{
+		Filters: []string{"ok", "(", "also ok", "[z-a]"},
+		Rules:   []Rule{{Subject: "fine"}, {Include: true, MinSize: "huge"}, {Extensions: []string{" "}}},
+		FlagRules: []Rule{
+			{Include: true, Subject: "("},
+			{Poster: "fine"},
+			{Poster: "["},
+			{Include: true, MaxAge: "old"},
+			{Include: true},
+		},
+	}
+
+	_, err := NewConfigFilter(config)
+	errs, ok := err.(RuleErrors)
+	if !ok || len(errs) != 8 {
+		t.Fatalf("NewConfigFilter() returned %v, want 8 RuleErrors", err)
+	}
+	if !errors.Is(errs[3], ErrEmptyRule) || !errors.Is(errs[7], ErrEmptyRule) {
+		t.Errorf("NewConfigFilter() returned %v and %v, want ErrEmptyRule", errs[3], errs[7])
+	}
+
+	for i, prefix := range []string{`filters[1] "("`, `filters[3] "[z-a]"`, `rules[1] include minsize=huge`, `rules[2] exclude ext= `, `-include #1 subject=(`, `-exclude #2 poster=[`, `-include #2 maxage=old`, `-include #3`} {
+		if !strings.HasPrefix(errs[i].Rule, prefix) {
+			t.Errorf("Error %d is for %q, want %q", i, errs[i].Rule, prefix)
+		}
+	}
+}
+
+func TestDecideLabels(t *testing.T) {
+	filter, err := NewConfigFilter(&Config{
+		Filters:   []string{`\.nfo`},
+		Rules:     []Rule{{Include: true, Extensions: []string{".mkv"}}},
+		FlagRules: []Rule{{Include: true, Extensions: []string{".srt"}}, {Poster: "^spam"}},
+	})
+	if err != nil {
+		t.Fatalf("NewConfigFilter() returned %v", err)
+	}
+	filter.Logger = &dumblog.DumbLog{Debug: false}
+
+	tests := []struct {
+		file  File
+		keep  bool
+		label string
+	}{
+		{File{Subject: `"movie.nfo" yEnc (1/1)`}, false, `filters[0] "\\.nfo"`},
+		{File{Subject: `"movie.mkv" yEnc (1/1)`}, true, "rules[0] include ext=.mkv"},
+		{File{Subject: `"movie.srt" yEnc (1/1)`}, true, "-include #1 ext=.srt"},
+		{File{Subject: `"movie.mkv" yEnc (1/1)`, Poster: "spammer"}, false, "-exclude #1 poster=^spam"},
+		{File{Subject: `"movie.jpg" yEnc (1/1)`}, false, ""},
+	}
+
+	for _, test := range tests {
+		keep, label := filter.Decide(&test.file)
+		if keep != test.keep || label != test.label {
+			t.Errorf("Decide(%q) = %v, %q, want %v, %q", test.file.Subject, keep, label, test.keep, test.label)
+		}
+	}
+}
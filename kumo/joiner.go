@@ -1,10 +1,9 @@
 package kumo
 
 import (
-	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,6 +13,38 @@ import (
 type fileTracker struct {
 	expected int
 	current  int
+	size     int64
+	file     *os.File
+	written  []Span
+}
+
+// Span is a range of bytes in a file, from Begin up to but not including End.
+type Span struct {
+	Begin int64
+	End   int64
+}
+
+// Returns the spans of [0, size) not covered by written.
+func missingSpans(written []Span, size int64) []Span {
+	sorted := make([]Span, len(written))
+	copy(sorted, written)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Begin < sorted[j].Begin })
+
+	var missing []Span
+	offset := int64(0)
+	for _, span := range sorted {
+		if span.Begin > offset {
+			missing = append(missing, Span{offset, span.Begin})
+		}
+		if span.End > offset {
+			offset = span.End
+		}
+	}
+	if offset < size {
+		missing = append(missing, Span{offset, size})
+	}
+
+	return missing
 }
 
 type Joiner struct {
@@ -21,8 +52,9 @@ type Joiner struct {
 	Stop           chan bool
 	Queue          chan *DecodedPart
 	Logger         *dumblog.DumbLog
-	TempPath       string
+	Memory         *memoryBudget
 	mu             sync.Mutex
+	damaged        map[string][]Span
 	segmentCount   map[string]int
 	segmentTracker map[string]*fileTracker
 	wait           *sync.WaitGroup
@@ -33,6 +65,7 @@ func InitJoiner(w *sync.WaitGroup) *Joiner {
 		DownloadPath:   "",
 		Queue:          make(chan *DecodedPart),
 		Stop:           make(chan bool, 1),
+		damaged:        make(map[string][]Span),
 		segmentTracker: make(map[string]*fileTracker),
 		segmentCount:   make(map[string]int),
 		wait:           w,
@@ -46,13 +79,6 @@ func (j *Joiner) SetSegmentCount(name string, count int) {
 	j.segmentCount[name] = count
 }
 
-func (j *Joiner) deleteSegmentCount(name string) {
-	j.mu.Lock()
-	defer j.mu.Unlock()
-
-	delete(j.segmentCount, name)
-}
-
 func (j *Joiner) Run() {
 	j.Logger.Printf("[JOINER] Joiner.Run()")
 	for {
@@ -65,22 +91,15 @@ func (j *Joiner) Run() {
 				defer j.Logger.Print("[JOINER] Done()")
 				defer j.wait.Done()
 
-				j.mu.Lock()
-				tracker, exists := j.segmentTracker[part.Name]
-				j.mu.Unlock()
-
-				if !exists {
-					j.Logger.Print("[JOINER] Part not in segmentTracker")
-
-					tracker = new(fileTracker)
-					tracker.current = 0
-					j.mu.Lock()
-					tracker.expected = j.segmentCount[part.SegmentName]
-					j.segmentTracker[part.Name] = tracker
-					j.mu.Unlock()
+				tracker, err := j.tracker(part)
+				if err != nil {
+					j.Logger.Print("[JOINER] Create fullFile err: ", err)
+					j.release(part)
+					return
 				}
 
-				j.deleteSegmentCount(part.SegmentName)
+				j.write(tracker, part)
+				j.release(part)
 
 				j.mu.Lock()
 				tracker.current++
@@ -92,11 +111,7 @@ func (j *Joiner) Run() {
 
 				if expected == current {
 					j.Logger.Print("[JOINER] expected == current")
-					j.wait.Add(1)
-					j.Logger.Print("[JOINER] Add(1)")
-					j.join(part.Name, expected)
-					j.wait.Done()
-					j.Logger.Print("[JOINER] Done()!")
+					j.join(part.Name)
 				}
 			}(part)
 		default:
@@ -105,71 +120,125 @@ func (j *Joiner) Run() {
 	}
 }
 
-func (j *Joiner) JoinAll() {
-	if len(j.segmentTracker) == 0 {
-		return
-	}
+// Returns the tracker for the file part belongs to, creating the file in
+// DownloadPath for its first part. The file is created at its full size so
+// that missing parts are left as zero filled holes.
+func (j *Joiner) tracker(part *DecodedPart) (*fileTracker, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
 
-	j.Logger.Print("[JOINER] JoinAll()")
-	j.Logger.Printf("[JOINER] j.segmentTracker: %+v", j.segmentTracker)
-	for k, tracker := range j.segmentTracker {
-		if tracker.current != tracker.expected {
-			j.join(k, tracker.expected)
+	tracker, exists := j.segmentTracker[part.Name]
+	if !exists {
+		j.Logger.Print("[JOINER] Part not in segmentTracker")
+
+		file, err := os.Create(filepath.Join(j.DownloadPath, part.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		if part.Size > 0 {
+			if err := file.Truncate(part.Size); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+
+		tracker = &fileTracker{
+			expected: j.segmentCount[part.SegmentName],
+			size:     part.Size,
+			file:     file,
 		}
+		j.segmentTracker[part.Name] = tracker
 	}
-}
 
-func (j *Joiner) join(filename string, count int) {
-	fullFilename := filepath.Join(j.DownloadPath, filename)
-	fullFile, err := os.Create(fullFilename)
-	defer fullFile.Close()
+	delete(j.segmentCount, part.SegmentName)
 
-	defer func() {
-		j.mu.Lock()
-		delete(j.segmentTracker, filename)
-		j.mu.Unlock()
-	}()
+	return tracker, nil
+}
 
-	if err != nil {
-		j.Logger.Print("[JOINER] Create fullFile err: ", err)
+// Writes part at its offset in the file, so parts can arrive in any order.
+func (j *Joiner) write(tracker *fileTracker, part *DecodedPart) {
+	offset := part.BeginSize - 1
+	if _, err := tracker.file.WriteAt(part.Body, offset); err != nil {
+		j.Logger.Print("[JOINER] got err writing ", part.Name, ": ", err)
 		return
 	}
 
-	// TODO: Fix broken file?
+	j.mu.Lock()
+	tracker.written = append(tracker.written, Span{offset, offset + int64(len(part.Body))})
+	j.mu.Unlock()
+
+	j.Logger.Print("[JOINER] Wrote ", len(part.Body), " bytes to ", part.Name, " at ", offset)
+}
+
+// Damaged returns the byte spans missing from each joined file that did not
+// get all of its parts.
+func (j *Joiner) Damaged() map[string][]Span {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	damaged := make(map[string][]Span, len(j.damaged))
+	for name, spans := range j.damaged {
+		damaged[name] = spans
+	}
+
+	return damaged
+}
 
-	j.Logger.Print("[JOINER] Joiner.Join(", filename, ", ", count, ")")
+func (j *Joiner) clearDamaged() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.damaged = make(map[string][]Span)
+}
 
-	if err != nil {
-		j.Logger.Print("[JOINER] error opening ", filename, ": ", err)
-		// TODO: Do something.
+func (j *Joiner) release(part *DecodedPart) {
+	putBuffer(part.buffer)
+	j.Memory.release(part.reserved)
+}
+
+// Closes any files still missing parts, leaving holes where they would be.
+func (j *Joiner) JoinAll() {
+	if len(j.segmentTracker) == 0 {
 		return
 	}
 
-	bytesWritten := 0
+	j.Logger.Print("[JOINER] JoinAll()")
+	j.Logger.Printf("[JOINER] j.segmentTracker: %+v", j.segmentTracker)
 
-	for i := 1; i < count+1; i++ {
-		partFilename := filepath.Join(j.TempPath, fmt.Sprintf("%v.%v", filename, i))
-		j.Logger.Print("[JOINER] Joining decoded part ", partFilename)
-		file, err := os.Open(partFilename)
-		if err != nil {
-			// Probably a missing segment, but continue...
-			j.Logger.Print("[JOINER] ", partFilename, " does not exist!")
-			continue
-		}
+	j.mu.Lock()
+	var names []string
+	for name := range j.segmentTracker {
+		names = append(names, name)
+	}
+	j.mu.Unlock()
 
-		defer os.Remove(partFilename)
+	for _, name := range names {
+		j.join(name)
+	}
+}
 
-		data, err := ioutil.ReadAll(file)
-		if err != nil {
-			j.Logger.Print("[JOINER] got err joining file: ", err)
-			// Probably a broken file, but continue...
-			continue
-		}
+// Finishes the file for filename once no more parts are expected.
+func (j *Joiner) join(filename string) {
+	j.mu.Lock()
+	tracker, exists := j.segmentTracker[filename]
+	delete(j.segmentTracker, filename)
+	j.mu.Unlock()
+
+	if !exists {
+		return
+	}
 
-		fullFile.Write(data)
-		bytesWritten += len(data)
+	if err := tracker.file.Close(); err != nil {
+		j.Logger.Print("[JOINER] got err closing ", filename, ": ", err)
+	}
+
+	if missing := missingSpans(tracker.written, tracker.size); len(missing) > 0 || tracker.current != tracker.expected {
+		j.Logger.Print("[JOINER] ", filename, " is missing ", tracker.expected-tracker.current, " of ", tracker.expected, " parts: ", missing)
+		j.mu.Lock()
+		j.damaged[filename] = missing
+		j.mu.Unlock()
 	}
 
 	j.Logger.Print("[JOINER] Done joining file ", filename)
-	j.Logger.Print("[JOINER] Wrote ", bytesWritten, " bytes")
 }
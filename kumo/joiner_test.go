@@ -0,0 +1,80 @@
+package kumo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/sww/dumblog"
+)
+
+func TestJoinerOutOfOrder(t *testing.T) {
+	var wait sync.WaitGroup
+	join := InitJoiner(&wait)
+	join.DownloadPath = t.TempDir()
+	join.Logger = &dumblog.DumbLog{Debug: false}
+	join.Memory = newMemoryBudget(1 << 20)
+	go join.Run()
+
+	parts := []*DecodedPart{
+		{Name: "file", Size: 9, BeginSize: 7, EndSize: 9, Body: []byte("ghi"), SegmentName: "3@foo"},
+		{Name: "file", Size: 9, BeginSize: 1, EndSize: 3, Body: []byte("abc"), SegmentName: "1@foo"},
+		{Name: "file", Size: 9, BeginSize: 4, EndSize: 6, Body: []byte("def"), SegmentName: "2@foo"},
+	}
+
+	wait.Add(len(parts))
+	for _, part := range parts {
+		join.SetSegmentCount(part.SegmentName, len(parts))
+	}
+	for _, part := range parts {
+		join.Queue <- part
+	}
+	wait.Wait()
+
+	data, err := ioutil.ReadFile(filepath.Join(join.DownloadPath, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abcdefghi" {
+		t.Errorf("Joined %q, want %q", data, "abcdefghi")
+	}
+}
+
+func TestJoinerMissingPart(t *testing.T) {
+	var wait sync.WaitGroup
+	join := InitJoiner(&wait)
+	join.DownloadPath = t.TempDir()
+	join.Logger = &dumblog.DumbLog{Debug: false}
+	join.Memory = newMemoryBudget(1 << 20)
+	go join.Run()
+
+	parts := []*DecodedPart{
+		{Name: "file", Size: 9, BeginSize: 1, EndSize: 3, Body: []byte("abc"), SegmentName: "1@foo"},
+		{Name: "file", Size: 9, BeginSize: 7, EndSize: 9, Body: []byte("ghi"), SegmentName: "3@foo"},
+	}
+
+	wait.Add(len(parts))
+	for _, part := range parts {
+		join.SetSegmentCount(part.SegmentName, 3)
+	}
+	for _, part := range parts {
+		join.Queue <- part
+	}
+	wait.Wait()
+	join.JoinAll()
+
+	data, err := ioutil.ReadFile(filepath.Join(join.DownloadPath, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abc\x00\x00\x00ghi" {
+		t.Errorf("Joined %q, want %q", data, "abc\x00\x00\x00ghi")
+	}
+
+	want := map[string][]Span{"file": {{3, 6}}}
+	if got := join.Damaged(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Damaged() returned %v, want %v", got, want)
+	}
+}
@@ -1,15 +1,50 @@
 package kumo
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/sww/dumblog"
+	"github.com/sww/kumo/par2"
 )
 
+// Megabytes of segment buffers held in memory when Config.Memory is unset.
+const defaultMemory = 256
+
+// JobResult is the outcome of downloading one NZB.
+type JobResult struct {
+	Name string
+	// Path is the directory the files were downloaded to.
+	Path string
+	// Category and Tags come from the NZB's <head>.
+	Category string
+	Tags     []string
+	// BrokenSegments counts segments that couldn't be downloaded in the
+	// last pass.
+	BrokenSegments int
+	// Verified is nil if no PAR2 files were downloaded.
+	Verified *par2.Result
+	// Repaired and Unrepairable count damaged PAR2 slices.
+	Repaired     int
+	Unrepairable int
+	// Needed is how many more recovery blocks repair needs.
+	Needed int
+	// Extracted lists the archives that were extracted, and ExtractErrors
+	// holds an *ExtractError for each one that wasn't.
+	Extracted     []string
+	ExtractErrors []error
+}
+
+// Failed returns whether the job left damaged or unextracted files behind.
+func (r *JobResult) Failed() bool {
+	return r.Unrepairable > 0 || len(r.ExtractErrors) > 0
+}
+
 type Kumo struct {
 	config   *Config
 	download *Download
@@ -21,20 +56,21 @@ type Kumo struct {
 }
 
 func New(config *Config) *Kumo {
-	if _, err := os.Stat(config.Temp); err != nil {
-		if os.Mkdir(config.Temp, 0775) != nil {
-			log.Fatalf("Cannot make temp directory %v", config.Temp)
-		}
-	}
-
 	var wait sync.WaitGroup
 
-	download, err := InitDownload(config.Host, config.Username, config.Password, config.Port, config.Connections, config.SSL, &wait)
+	// Check the filters before connecting to anything.
+	filter, err := NewConfigFilter(config)
+	if err != nil {
+		log.Fatalf("Bad filters:\n%v\n", err)
+	}
+
+	download, err := InitDownload(config.ServerList(), &wait)
 	if err != nil {
 		log.Fatalf("Failed to InitDownload, with error: %v\n", err)
 	}
 
-	filter := NewFilter(config.Filters...)
+	download.Retries = config.Retries
+	download.RetryDelay = time.Duration(config.RetryDelay) * time.Millisecond
 
 	logger := dumblog.New(config.Debug)
 	if config.DebugFile != "" {
@@ -52,6 +88,15 @@ func New(config *Config) *Kumo {
 	download.DecodeQueue = decode.Queue
 	decode.JoinQueue = join.Queue
 
+	memory := config.Memory
+	if memory <= 0 {
+		memory = defaultMemory
+	}
+	budget := newMemoryBudget(int64(memory) * int64(MB))
+	download.Memory = budget
+	decode.Memory = budget
+	join.Memory = budget
+
 	filter.Logger = logger
 	download.Logger = logger
 	decode.Logger = logger
@@ -68,71 +113,191 @@ func New(config *Config) *Kumo {
 	}
 }
 
-func (k *Kumo) Get(filename string) error {
+// Returns name with anything that could make it a path replaced, or "" if
+// nothing usable is left.
+func safeName(name string) string {
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name))
+	if name == "." || name == ".." {
+		return ""
+	}
+
+	return name
+}
+
+func (k *Kumo) Get(filename string) (*JobResult, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	nzb, err := Parse(file)
+	file.Close()
 	if err != nil {
-		log.Fatalf("[KUMO] Failed to parse with error %v\n", err)
-		return err
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
 	}
 
-	_, dirName := filepath.Split(strings.TrimSuffix(filename, filepath.Ext(filename)))
-	tempPath := filepath.Join(k.config.Temp, dirName)
-	downloadPath := filepath.Join(k.config.Download, dirName)
+	for _, problem := range nzb.Normalize() {
+		k.logger.Printf("[KUMO] %v", problem)
+		if problem.Fatal && !k.config.Quiet {
+			fmt.Printf("%s %v\n", red(PREFIX_COMPLETE_BROKEN), problem)
+		}
+	}
+	if len(nzb.Files) == 0 {
+		return nil, ErrEmptyNZB
+	}
 
-	k.download.TempPath = tempPath
-	k.decode.TempPath = tempPath
-	k.join.TempPath = tempPath
-	k.join.DownloadPath = downloadPath
+	dirName, password := splitPassword(nzbName(filename))
+	// Name the job after the NZB's title, or the collection its subjects
+	// share, before falling back to the NZB's filename.
+	if name := safeName(nzb.Title()); name != "" {
+		dirName = name
+	} else if name := safeName(nzb.Name()); name != "" {
+		dirName = name
+	}
+	k.join.DownloadPath = filepath.Join(k.config.downloadDir(nzb.Category()), dirName)
+	k.join.clearDamaged()
 
-	k.logger.Printf("[KUMO] Creating temp path: '%v'", k.download.TempPath)
-	os.Mkdir(k.download.TempPath, 0775)
 	k.logger.Printf("[KUMO] Creating download path: '%v'", k.join.DownloadPath)
-	os.Mkdir(k.join.DownloadPath, 0775)
+	os.MkdirAll(k.join.DownloadPath, 0775)
 
 	if k.filter.HasFilters() {
 		nzb = k.filter.FilterNzb(nzb)
 	}
+	nzb.Sort()
 
 	progress := NewProgress()
 
+	// Download the files with the PAR2 index files, which are small, and
+	// only fetch recovery volumes once the index shows how many are needed.
 	nzbs := k.filter.Split(nzb, ".par2")
+	index, volumes := splitVolumes(nzbs[1])
+	first := nzbs[0]
+	first.Files = append(first.Files, index.Files...)
 	if k.config.PAR2 {
-		nzbs = nzbs[len(nzbs)-1:]
+		first = nzbs[1]
+		volumes = nil
 	}
 
-	for _, nzb = range nzbs {
-		progress.SetTotalSize(nzb.Size())
+	result := &JobResult{
+		Name:           dirName,
+		Path:           k.join.DownloadPath,
+		Category:       nzb.Category(),
+		Tags:           nzb.Tags(),
+		BrokenSegments: k.pass(first, progress),
+	}
+	k.check(result)
+
+	for len(volumes) > 0 {
+		var picked []File
+		switch {
+		case result.Verified == nil && result.BrokenSegments > 0:
+			// Without a usable index there's no telling what is needed.
+			picked, volumes = volumes, nil
+		case result.Needed > 0:
+			picked, volumes = pickVolumes(volumes, result.Needed)
+		}
+		if len(picked) == 0 {
+			break
+		}
 
-		k.download.Progress = progress
-		k.decode.Progress = progress
+		k.logger.Printf("[KUMO] Downloading %d PAR2 volumes for %d recovery blocks", len(picked), result.Needed)
+		result.BrokenSegments = k.pass(&NZB{Files: picked}, progress)
+		k.check(result)
+	}
 
-		if !k.config.Quiet {
-			go progress.Run()
+	if k.config.Extract && result.Unrepairable == 0 {
+		// Passwords from the NZB come before the configured ones.
+		var passwords []string
+		for _, p := range []string{nzb.Password(), password} {
+			if p != "" {
+				passwords = append(passwords, p)
+			}
 		}
+		k.extract(result, append(passwords, k.config.Passwords...))
+	}
 
-		k.get(nzb)
+	for _, pool := range k.download.ConnectionPools {
+		for i, stats := range pool.stats() {
+			k.logger.Printf("[KUMO] %v #%d compression ratio %.2f (%v/%v)", stats.Server, i, stats.Ratio(), ByteSize(stats.CompressedBytes), ByteSize(stats.UncompressedBytes))
+		}
+	}
 
-		k.logger.Printf("[KUMO] wait.Wait()")
-		k.wait.Wait()
-		k.join.JoinAll()
+	return result, nil
+}
 
-		progress.Wait.Wait()
+// Downloads the files in nzb, returning the number of broken segments.
+func (k *Kumo) pass(nzb *NZB, progress *Progress) int {
+	if len(nzb.Files) == 0 {
+		return 0
+	}
 
-		if !progress.isBroken() {
-			break
-		} else {
-			progress.reset()
-			progress.prefix = PREFIX_PAR2
-		}
+	progress.SetTotalSize(nzb.Size())
+
+	k.download.Progress = progress
+	k.decode.Progress = progress
+
+	if !k.config.Quiet {
+		go progress.Run()
+	}
+
+	k.get(nzb)
+
+	k.logger.Printf("[KUMO] wait.Wait()")
+	k.wait.Wait()
+	k.join.JoinAll()
+
+	progress.Wait.Wait()
+
+	broken := progress.brokenSegments
+	progress.reset()
+	progress.prefix = PREFIX_PAR2
+
+	return broken
+}
+
+// Verifies the downloaded files against their PAR2 files, if there are any,
+// and repairs them if needed.
+func (k *Kumo) check(result *JobResult) {
+	result.Repaired = 0
+	result.Unrepairable = 0
+	result.Needed = 0
+
+	set := k.readPAR2(result.Path)
+	if set == nil {
+		return
 	}
 
-	os.RemoveAll(k.download.TempPath)
+	k.deobfuscate(set, result.Path)
+
+	verified, err := k.verify(set, result.Path)
+	if err != nil {
+		k.logger.Printf("[KUMO] Failed to verify with error %v", err)
+		return
+	}
+	result.Verified = verified
 
-	return nil
+	if verified.OK() {
+		return
+	}
+
+	repair, err := k.repair(set, result.Path, verified)
+	if err != nil {
+		k.logger.Printf("[KUMO] Failed to repair with error %v", err)
+		result.Unrepairable = verified.DamagedSlices()
+		return
+	}
+	result.Repaired = repair.Repaired
+	result.Unrepairable = repair.Unrepairable
+
+	result.Needed = repair.Needed
+	if repair.Needed == 0 && repair.Unrepairable > 0 {
+		// There were enough recovery blocks but some were damaged.
+		result.Needed = repair.Unrepairable
+	}
 }
 
 func (k *Kumo) get(nzb *NZB) {
@@ -150,7 +315,9 @@ func (k *Kumo) get(nzb *NZB) {
 		for _, segment := range nzbFile.Segments {
 			k.logger.Print("[KUMO] Queuing ", segment)
 			k.join.SetSegmentCount(segment.Segment, numSegments)
-			segment.Group = nzbFile.Groups[0]
+			if len(nzbFile.Groups) > 0 {
+				segment.Group = nzbFile.Groups[0]
+			}
 			k.download.Queue <- segment
 		}
 	}
@@ -1,16 +1,109 @@
 package kumo
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"regexp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type NZB struct {
+	Meta  []Meta `xml:"head>meta"`
 	Files []File `xml:"file"`
 }
 
+// Meta is a <meta> element from the NZB's <head>, such as its title,
+// password, category or tags.
+type Meta struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Returns the values of the meta elements of type kind, in order.
+func (n *NZB) meta(kind string) []string {
+	var values []string
+	for _, meta := range n.Meta {
+		if value := strings.TrimSpace(meta.Value); strings.EqualFold(meta.Type, kind) && value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func (n *NZB) first(kind string) string {
+	if values := n.meta(kind); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+func (n *NZB) Title() string {
+	return n.first("title")
+}
+
+func (n *NZB) Password() string {
+	return n.first("password")
+}
+
+func (n *NZB) Category() string {
+	return n.first("category")
+}
+
+func (n *NZB) Tags() []string {
+	return n.meta("tag")
+}
+
+// Name returns the collection name that all the files' subjects share, or
+// "" if they don't share one.
+func (n *NZB) Name() string {
+	name := ""
+	for i, file := range n.Files {
+		s := file.ParsedSubject()
+		if i > 0 && s.Name != name {
+			return ""
+		}
+		name = s.Name
+	}
+
+	return name
+}
+
+// Sort orders the files by their [n/m] index, and then by filename.
+func (n *NZB) Sort() {
+	type entry struct {
+		file    File
+		subject Subject
+	}
+
+	entries := make([]entry, len(n.Files))
+	for i, file := range n.Files {
+		entries[i] = entry{file, file.ParsedSubject()}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].subject, entries[j].subject
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		return a.Filename < b.Filename
+	})
+
+	for i := range entries {
+		n.Files[i] = entries[i].file
+	}
+}
+
 type File struct {
 	Poster   string    `xml:"poster,attr"`
 	Subject  string    `xml:"subject,attr"`
@@ -19,45 +112,180 @@ type File struct {
 	Segments []Segment `xml:"segments>segment"`
 }
 
-func (f *File) Extension() string {
-	re := regexp.MustCompile("\\.\\w+")
-	matches := re.FindAllString(f.Subject, -1)
-	if len(matches) > 0 {
-		return matches[len(matches)-1]
+// Size returns the total size of the file's segments.
+func (f *File) Size() int64 {
+	size := int64(0)
+	for _, segment := range f.Segments {
+		size += segment.Bytes
 	}
 
-	return ""
+	return size
+}
+
+// ParsedSubject returns the file's subject, parsed.
+func (f *File) ParsedSubject() Subject {
+	return ParseSubject(f.Subject)
+}
+
+func (f *File) Extension() string {
+	return f.ParsedSubject().Extension()
 }
 
 type Segment struct {
 	Bytes   int64  `xml:"bytes,attr"`
 	Number  int    `xml:"number,attr"`
 	Segment string `xml:",chardata"`
-	Group   string
+	Group   string `xml:"-"`
 }
 
 func (n *NZB) Size() int64 {
 	size := int64(0)
 	for _, file := range n.Files {
-		for _, segment := range file.Segments {
-			size += segment.Bytes
-		}
+		size += file.Size()
 	}
 
 	return size
 }
 
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+
+	ErrNotNZB   = errors.New("not an NZB")
+	ErrNoNZBZip = errors.New("no NZB in zip file")
+)
+
+// Parse reads an NZB from f, which may also be gzipped, or a zip file of
+// NZBs. The NZBs in a zip file are merged into one.
 func Parse(f io.Reader) (*NZB, error) {
-	data, err := ioutil.ReadAll(f)
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(len(zipMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		return parseXML(gz)
+	case bytes.HasPrefix(magic, zipMagic):
+		// Zip files are read from the end, so they can't be streamed, but
+		// files can be read in place.
+		if file, ok := f.(*os.File); ok {
+			if info, err := file.Stat(); err == nil {
+				return parseZip(file, info.Size())
+			}
+		}
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseZip(bytes.NewReader(data), int64(len(data)))
+	}
+
+	return parseXML(r)
+}
+
+func parseZip(r io.ReaderAt, size int64) (*NZB, error) {
+	archive, err := zip.NewReader(r, size)
 	if err != nil {
 		return nil, err
 	}
 
 	nzb := new(NZB)
-	err = xml.Unmarshal(data, &nzb)
+	found := false
+	for _, file := range archive.File {
+		ext := strings.ToLower(filepath.Ext(file.Name))
+		if file.FileInfo().IsDir() || (ext != ".nzb" && ext != ".gz") {
+			continue
+		}
+
+		part, err := parseZipFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+
+		nzb.Meta = append(nzb.Meta, part.Meta...)
+		nzb.Files = append(nzb.Files, part.Files...)
+		found = true
+	}
+
+	if !found {
+		return nil, ErrNoNZBZip
+	}
+
+	return nzb, nil
+}
+
+func parseZipFile(file *zip.File) (*NZB, error) {
+	rc, err := file.Open()
 	if err != nil {
 		return nil, err
 	}
+	defer rc.Close()
+
+	return Parse(rc)
+}
+
+// Reads the NZB from r one <file> at a time, rather than all at once.
+func parseXML(r io.Reader) (*NZB, error) {
+	decoder := xml.NewDecoder(r)
+
+	nzb := new(NZB)
+	root := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "nzb":
+			root = true
+		case "head":
+			var head struct {
+				Meta []Meta `xml:"meta"`
+			}
+			if err := decoder.DecodeElement(&head, &start); err != nil {
+				return nil, err
+			}
+			nzb.Meta = append(nzb.Meta, head.Meta...)
+		case "file":
+			var file File
+			if err := decoder.DecodeElement(&file, &start); err != nil {
+				return nil, err
+			}
+			nzb.Files = append(nzb.Files, file)
+		}
+	}
+
+	if !root {
+		return nil, ErrNotNZB
+	}
 
 	return nzb, nil
 }
+
+// Returns the name of an NZB file without its .nzb, .nzb.gz or .zip
+// extension.
+func nzbName(filename string) string {
+	_, name := filepath.Split(filename)
+	for _, ext := range []string{".gz", ".zip", ".nzb"} {
+		if strings.EqualFold(filepath.Ext(name), ext) {
+			name = name[:len(name)-len(ext)]
+		}
+	}
+
+	return name
+}
@@ -1,6 +1,9 @@
 package kumo
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"reflect"
 	"strings"
 	"testing"
@@ -44,3 +47,96 @@ func Test_Size(t *testing.T) {
 		t.Errorf("Returned %+v, want %+v", nzb, &want)
 	}
 }
+
+func Test_ParseHead(t *testing.T) {
+	content := strings.NewReader(`
+<?xml version="1.0" encoding="utf-8" ?>
+<nzb xmlns="http://www.newzbin.com/DTD/2003/nzb">
+    <head>
+        <meta type="title">Some Title</meta>
+        <meta type="password"> secret </meta>
+        <meta type="category">TV</meta>
+        <meta type="tag">HD</meta>
+        <meta type="tag">English</meta>
+    </head>
+    <file poster="poster" date="123" subject="subject">
+        <groups><group>alt.group</group></groups>
+        <segments><segment bytes="11" number="1">1@foo.com</segment></segments>
+    </file>
+</nzb>
+`)
+	nzb, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if nzb.Title() != "Some Title" || nzb.Password() != "secret" || nzb.Category() != "TV" {
+		t.Errorf("Returned title %q, password %q, category %q", nzb.Title(), nzb.Password(), nzb.Category())
+	}
+	if tags := nzb.Tags(); !reflect.DeepEqual(tags, []string{"HD", "English"}) {
+		t.Errorf("Returned tags %q", tags)
+	}
+	if len(nzb.Files) != 1 {
+		t.Errorf("Returned %d files, want 1", len(nzb.Files))
+	}
+}
+
+const testNZB = `<?xml version="1.0" encoding="utf-8" ?>
+<nzb xmlns="http://www.newzbin.com/DTD/2003/nzb">
+    <file poster="poster" date="123" subject="subject">
+        <groups><group>alt.group</group></groups>
+        <segments><segment bytes="11" number="1">1@foo.com</segment></segments>
+    </file>
+</nzb>`
+
+func Test_ParseCompressed(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(testNZB))
+	gz.Close()
+
+	var zipped bytes.Buffer
+	z := zip.NewWriter(&zipped)
+	for _, name := range []string{"one.nzb", "two.nzb", "readme.txt"} {
+		w, _ := z.Create(name)
+		w.Write([]byte(testNZB))
+	}
+	z.Close()
+
+	tests := []struct {
+		name  string
+		data  []byte
+		files int
+	}{
+		{"gzip", gzipped.Bytes(), 1},
+		{"zip", zipped.Bytes(), 2},
+	}
+	for _, test := range tests {
+		nzb, err := Parse(bytes.NewReader(test.data))
+		if err != nil {
+			t.Errorf("Parse(%s) error: %v", test.name, err)
+			continue
+		}
+		if len(nzb.Files) != test.files || nzb.Files[0].Segments[0].Segment != "1@foo.com" {
+			t.Errorf("Parse(%s) returned %+v, want %d files", test.name, nzb, test.files)
+		}
+	}
+
+	if _, err := Parse(strings.NewReader("<html></html>")); err != ErrNotNZB {
+		t.Errorf("Parse() returned %v, want ErrNotNZB", err)
+	}
+}
+
+func Test_nzbName(t *testing.T) {
+	for filename, want := range map[string]string{
+		"dir/foo.nzb":   "foo",
+		"foo.nzb.gz":    "foo",
+		"foo.zip":       "foo",
+		"foo.bar":       "foo.bar",
+		"foo{{pw}}.nzb": "foo{{pw}}",
+	} {
+		if got := nzbName(filename); got != want {
+			t.Errorf("nzbName(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
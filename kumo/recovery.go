@@ -0,0 +1,82 @@
+package kumo
+
+// Splits PAR2 files into the index files, which carry no recovery blocks,
+// and the .volXX+YY.par2 recovery volumes.
+func splitVolumes(nzb *NZB) (*NZB, []File) {
+	var index NZB
+	var volumes []File
+	for _, file := range nzb.Files {
+		if par2Blocks(file.Subject) > 0 {
+			volumes = append(volumes, file)
+		} else {
+			index.Files = append(index.Files, file)
+		}
+	}
+
+	return &index, volumes
+}
+
+// Picks the volumes with the fewest recovery blocks in total, and then the
+// fewest files, that together have at least need blocks. The volumes not
+// picked are returned as rest. If there aren't enough blocks, all the
+// volumes are picked.
+func pickVolumes(volumes []File, need int) (picked, rest []File) {
+	if need <= 0 {
+		return nil, volumes
+	}
+
+	total := 0
+	for _, volume := range volumes {
+		total += par2Blocks(volume.Subject)
+	}
+	if total <= need {
+		return volumes, nil
+	}
+
+	// files[b] is the fewest volumes with exactly b blocks between them, or
+	// -1 if no combination has b blocks. take[i][b] records whether volume i
+	// is part of that combination, once the first i+1 volumes are considered.
+	files := make([]int, total+1)
+	for b := range files {
+		files[b] = -1
+	}
+	files[0] = 0
+
+	take := make([][]bool, len(volumes))
+	for i, volume := range volumes {
+		blocks := par2Blocks(volume.Subject)
+		take[i] = make([]bool, total+1)
+		for b := total; b >= blocks; b-- {
+			if files[b-blocks] < 0 {
+				continue
+			}
+			if files[b] < 0 || files[b-blocks]+1 < files[b] {
+				files[b] = files[b-blocks] + 1
+				take[i][b] = true
+			}
+		}
+	}
+
+	b := need
+	for files[b] < 0 {
+		b++
+	}
+
+	chosen := make([]bool, len(volumes))
+	for i := len(volumes) - 1; i >= 0; i-- {
+		if take[i][b] {
+			chosen[i] = true
+			b -= par2Blocks(volumes[i].Subject)
+		}
+	}
+
+	for i, volume := range volumes {
+		if chosen[i] {
+			picked = append(picked, volume)
+		} else {
+			rest = append(rest, volume)
+		}
+	}
+
+	return picked, rest
+}
@@ -0,0 +1,50 @@
+package kumo
+
+import "testing"
+
+func TestPickVolumes(t *testing.T) {
+	var volumes []File
+	for _, subject := range []string{
+		`"file.vol00+01.par2" yEnc (1/1)`,
+		`"file.vol01+02.par2" yEnc (1/2)`,
+		`"file.vol03+04.par2" yEnc (1/4)`,
+		`"file.vol07+08.par2" yEnc (1/8)`,
+	} {
+		volumes = append(volumes, File{Subject: subject})
+	}
+
+	tests := []struct {
+		need   int
+		picked []int
+	}{
+		{0, nil},
+		{1, []int{1}},
+		{3, []int{1, 2}},
+		{4, []int{4}},
+		{5, []int{1, 4}},
+		{11, []int{1, 2, 8}},
+		{16, []int{1, 2, 4, 8}},
+	}
+
+	for _, test := range tests {
+		picked, rest := pickVolumes(volumes, test.need)
+		if len(picked)+len(rest) != len(volumes) {
+			t.Errorf("pickVolumes(%d) split %d volumes into %d and %d", test.need, len(volumes), len(picked), len(rest))
+		}
+
+		var blocks []int
+		for _, volume := range picked {
+			blocks = append(blocks, par2Blocks(volume.Subject))
+		}
+		if len(blocks) != len(test.picked) {
+			t.Errorf("pickVolumes(%d) picked %v, want %v", test.need, blocks, test.picked)
+			continue
+		}
+		for i := range blocks {
+			if blocks[i] != test.picked[i] {
+				t.Errorf("pickVolumes(%d) picked %v, want %v", test.need, blocks, test.picked)
+				break
+			}
+		}
+	}
+}
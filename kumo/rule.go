@@ -0,0 +1,266 @@
+package kumo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrEmptyRule is a rule without criteria, which would match every file.
+// It usually means a misspelled key in the config.
+var ErrEmptyRule = errors.New("rule has no criteria")
+
+// Rule is a filter rule. A file matches a rule when it matches every
+// criterion the rule sets. Files matching an exclude rule are dropped, and
+// if there are include rules, files matching none of them are dropped too.
+type Rule struct {
+	Include    bool
+	Subject    string   // regexp matched against the subject
+	Poster     string   // regexp matched against the poster
+	Group      string   // regexp matched against each of the groups
+	Extensions []string // such as ".mkv", from the filename in the subject
+	MinSize    string   // total size of the file's segments, such as "100MB"
+	MaxSize    string
+	MinAge     string // age of the post, such as "36h" or "30d"
+	MaxAge     string
+}
+
+func (r Rule) String() string {
+	action := "exclude"
+	if r.Include {
+		action = "include"
+	}
+
+	return action + " " + r.criteria()
+}
+
+// Returns the rule's criteria in the form ParseRule takes.
+func (r Rule) criteria() string {
+	var criteria []string
+	add := func(key, value string) {
+		if value != "" {
+			criteria = append(criteria, key+"="+value)
+		}
+	}
+
+	add("subject", r.Subject)
+	add("poster", r.Poster)
+	add("group", r.Group)
+	add("ext", strings.Join(r.Extensions, ","))
+	add("minsize", r.MinSize)
+	add("maxsize", r.MaxSize)
+	add("minage", r.MinAge)
+	add("maxage", r.MaxAge)
+
+	return strings.Join(criteria, " ")
+}
+
+// ParseRule parses a rule given as space separated key=value criteria, as
+// in "ext=.mkv,.srt minsize=100MB". The keys are subject, poster, group,
+// ext, minsize, maxsize, minage and maxage.
+func ParseRule(s string, include bool) (Rule, error) {
+	rule := Rule{Include: include}
+	for _, criterion := range strings.Fields(s) {
+		i := strings.Index(criterion, "=")
+		if i < 0 {
+			return rule, fmt.Errorf("criterion %q isn't key=value", criterion)
+		}
+
+		key, value := strings.ToLower(criterion[:i]), criterion[i+1:]
+		switch key {
+		case "subject":
+			rule.Subject = value
+		case "poster":
+			rule.Poster = value
+		case "group":
+			rule.Group = value
+		case "ext":
+			rule.Extensions = strings.Split(value, ",")
+		case "minsize":
+			rule.MinSize = value
+		case "maxsize":
+			rule.MaxSize = value
+		case "minage":
+			rule.MinAge = value
+		case "maxage":
+			rule.MaxAge = value
+		default:
+			return rule, fmt.Errorf("unknown criterion %q", key)
+		}
+	}
+
+	if _, err := rule.compile(); err != nil {
+		return rule, err
+	}
+
+	return rule, nil
+}
+
+type compiledRule struct {
+	rule       Rule
+	label      string
+	subject    *regexp.Regexp
+	poster     *regexp.Regexp
+	group      *regexp.Regexp
+	extensions []string
+	minSize    int64
+	maxSize    int64
+	minAge     time.Duration
+	maxAge     time.Duration
+}
+
+func (r Rule) compile() (*compiledRule, error) {
+	c := &compiledRule{rule: r}
+
+	var err error
+	for _, re := range []struct {
+		name    string
+		pattern string
+		dst     **regexp.Regexp
+	}{
+		{"subject", r.Subject, &c.subject},
+		{"poster", r.Poster, &c.poster},
+		{"group", r.Group, &c.group},
+	} {
+		if re.pattern == "" {
+			continue
+		}
+		if *re.dst, err = regexp.Compile(re.pattern); err != nil {
+			return nil, fmt.Errorf("%s: %v", re.name, err)
+		}
+	}
+
+	for _, ext := range r.Extensions {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			c.extensions = append(c.extensions, "."+strings.TrimPrefix(ext, "."))
+		}
+	}
+
+	for _, size := range []struct {
+		name  string
+		value string
+		dst   *int64
+	}{
+		{"minsize", r.MinSize, &c.minSize},
+		{"maxsize", r.MaxSize, &c.maxSize},
+	} {
+		if size.value == "" {
+			continue
+		}
+		if *size.dst, err = parseSize(size.value); err != nil {
+			return nil, fmt.Errorf("%s: %v", size.name, err)
+		}
+	}
+
+	for _, age := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"minage", r.MinAge, &c.minAge},
+		{"maxage", r.MaxAge, &c.maxAge},
+	} {
+		if age.value == "" {
+			continue
+		}
+		if *age.dst, err = parseAge(age.value); err != nil {
+			return nil, fmt.Errorf("%s: %v", age.name, err)
+		}
+	}
+
+	if c.subject == nil && c.poster == nil && c.group == nil && len(c.extensions) == 0 &&
+		r.MinSize == "" && r.MaxSize == "" && r.MinAge == "" && r.MaxAge == "" {
+		return nil, ErrEmptyRule
+	}
+
+	return c, nil
+}
+
+func (c *compiledRule) matches(file *File, now time.Time) bool {
+	if c.subject != nil && !c.subject.MatchString(file.Subject) {
+		return false
+	}
+	if c.poster != nil && !c.poster.MatchString(file.Poster) {
+		return false
+	}
+	if c.group != nil && !matchesAny(c.group, file.Groups) {
+		return false
+	}
+
+	if len(c.extensions) > 0 {
+		ext := file.Extension()
+		found := false
+		for _, want := range c.extensions {
+			if strings.EqualFold(ext, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	size := file.Size()
+	if (c.minSize > 0 && size < c.minSize) || (c.maxSize > 0 && size > c.maxSize) {
+		return false
+	}
+
+	if c.minAge > 0 || c.maxAge > 0 {
+		age := now.Sub(time.Unix(int64(file.Date), 0))
+		if (c.minAge > 0 && age < c.minAge) || (c.maxAge > 0 && age > c.maxAge) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesAny(re *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var sizeRe = regexp.MustCompile(`(?i)^\s*(\d+(?:\.\d+)?)\s*([KMGT]?)B?\s*$`)
+
+// Parses a size such as "700MB" or "1.5G", in powers of 1024.
+func parseSize(s string) (int64, error) {
+	matches := sizeRe.FindStringSubmatch(s)
+	if matches == nil {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+
+	n, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	unit := map[string]ByteSize{"": 1, "K": KB, "M": MB, "G": GB, "T": TB}[strings.ToUpper(matches[2])]
+	return int64(n * float64(unit)), nil
+}
+
+// Parses an age such as "36h", or "30d" in days.
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad age %q", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+
+	age, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad age %q", s)
+	}
+
+	return age, nil
+}
@@ -0,0 +1,88 @@
+package kumo
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	subjectFilenameRe = regexp.MustCompile(`"([^"]+)"`)
+	subjectIndexRe    = regexp.MustCompile(`\[(\d+)\s*/\s*(\d+)\]`)
+	subjectSegmentsRe = regexp.MustCompile(`\((\d+)\s*/\s*(\d+)\)`)
+	// Used when the filename isn't quoted, a word with an extension.
+	subjectBareNameRe = regexp.MustCompile(`[^\s"]+\.[A-Za-z0-9]{1,5}\b`)
+	// Volume suffixes stripped from a filename to get a collection name.
+	volumeSuffixRe = regexp.MustCompile(`(?i)(\.part\d+\.rar|\.vol\d+\+\d+\.par2|\.r\d{2,3}|\.\d{3}|\.[a-z0-9]{1,5})$`)
+)
+
+// Subject is what can be made out of a yEnc post's subject, as in
+// `Some.Show.S01E01 [01/45] - "foo.part01.rar" yEnc (1/120)`.
+type Subject struct {
+	// Name is the collection the file belongs to, Some.Show.S01E01 above.
+	Name     string
+	Filename string
+	// Index and Total are the file's place in the collection, from [n/m].
+	Index int
+	Total int
+	// Segment and Segments are from the last (x/y).
+	Segment  int
+	Segments int
+}
+
+// ParseSubject parses subject, leaving out whatever it doesn't have.
+func ParseSubject(subject string) Subject {
+	var s Subject
+
+	filenameAt := -1
+	if matches := subjectFilenameRe.FindAllStringSubmatchIndex(subject, -1); matches != nil {
+		last := matches[len(matches)-1]
+		s.Filename = strings.TrimSpace(subject[last[2]:last[3]])
+		filenameAt = last[0]
+	} else if matches := subjectBareNameRe.FindAllStringIndex(withoutCounts(subject), -1); matches != nil {
+		last := matches[len(matches)-1]
+		s.Filename = subject[last[0]:last[1]]
+		filenameAt = last[0]
+	}
+
+	indexAt := -1
+	if loc := subjectIndexRe.FindStringSubmatchIndex(subject); loc != nil {
+		s.Index, _ = strconv.Atoi(subject[loc[2]:loc[3]])
+		s.Total, _ = strconv.Atoi(subject[loc[4]:loc[5]])
+		indexAt = loc[0]
+	}
+
+	if matches := subjectSegmentsRe.FindAllStringSubmatch(subject, -1); matches != nil {
+		last := matches[len(matches)-1]
+		s.Segment, _ = strconv.Atoi(last[1])
+		s.Segments, _ = strconv.Atoi(last[2])
+	}
+
+	// The collection name comes before the index or filename, whichever is
+	// first, and falls back to the filename without its extensions.
+	end := indexAt
+	if end < 0 || (filenameAt >= 0 && filenameAt < end) {
+		end = filenameAt
+	}
+	if end > 0 {
+		s.Name = strings.Trim(subject[:end], " -_\"")
+	}
+	if s.Name == "" && s.Filename != "" {
+		s.Name = volumeSuffixRe.ReplaceAllString(s.Filename, "")
+	}
+
+	return s
+}
+
+// Blanks out the (x/y) and [n/m] counts, keeping the length the same.
+func withoutCounts(subject string) string {
+	blank := func(m string) string { return strings.Repeat(" ", len(m)) }
+	subject = subjectSegmentsRe.ReplaceAllStringFunc(subject, blank)
+	return subjectIndexRe.ReplaceAllStringFunc(subject, blank)
+}
+
+// Extension returns the extension of the parsed filename.
+func (s Subject) Extension() string {
+	return filepath.Ext(s.Filename)
+}
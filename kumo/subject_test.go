@@ -0,0 +1,56 @@
+package kumo
+
+import "testing"
+
+func TestParseSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    Subject
+	}{
+		{
+			`Some.Show.S01E01 [01/45] - "foo.part01.rar" yEnc (1/120)`,
+			Subject{Name: "Some.Show.S01E01", Filename: "foo.part01.rar", Index: 1, Total: 45, Segment: 1, Segments: 120},
+		},
+		{
+			`"foo.vol03+04.par2" yEnc (1/2)`,
+			Subject{Name: "foo", Filename: "foo.vol03+04.par2", Segment: 1, Segments: 2},
+		},
+		{
+			`[3/5] "Movie (2010).mkv.002" yEnc (7/9)`,
+			Subject{Name: "Movie (2010).mkv", Filename: "Movie (2010).mkv.002", Index: 3, Total: 5, Segment: 7, Segments: 9},
+		},
+		{
+			`Collection - file.nfo yEnc (1/1)`,
+			Subject{Name: "Collection", Filename: "file.nfo", Segment: 1, Segments: 1},
+		},
+		{
+			`no filename here`,
+			Subject{},
+		},
+	}
+
+	for _, test := range tests {
+		if got := ParseSubject(test.subject); got != test.want {
+			t.Errorf("ParseSubject(%q) = %+v, want %+v", test.subject, got, test.want)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	nzb := NZB{Files: []File{
+		{Subject: `Show [3/3] - "show.par2" yEnc (1/1)`},
+		{Subject: `Show [1/3] - "show.part01.rar" yEnc (1/9)`},
+		{Subject: `Show [2/3] - "show.part02.rar" yEnc (1/9)`},
+	}}
+
+	nzb.Sort()
+	for i, want := range []string{"show.part01.rar", "show.part02.rar", "show.par2"} {
+		if got := nzb.Files[i].ParsedSubject().Filename; got != want {
+			t.Errorf("File %d is %q, want %q", i, got, want)
+		}
+	}
+
+	if name := nzb.Name(); name != "Show" {
+		t.Errorf("Name() = %q, want %q", name, "Show")
+	}
+}
@@ -0,0 +1,177 @@
+package kumo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Most missing segment numbers listed in a problem.
+const maxGaps = 10
+
+var ErrEmptyNZB = errors.New("no downloadable files in NZB")
+
+// Problem is something wrong with a file in an NZB.
+type Problem struct {
+	Subject string
+	Message string
+	// Fatal problems leave nothing to download, so Normalize drops the file.
+	Fatal bool
+}
+
+func (p Problem) String() string {
+	if p.Fatal {
+		return fmt.Sprintf("%q dropped: %s", p.Subject, p.Message)
+	}
+
+	return fmt.Sprintf("%q: %s", p.Subject, p.Message)
+}
+
+// Validate returns the problems Normalize would find, without changing the
+// NZB.
+func (n *NZB) Validate() []Problem {
+	clone := &NZB{Meta: n.Meta, Files: make([]File, len(n.Files))}
+	for i, file := range n.Files {
+		clone.Files[i] = file
+		clone.Files[i].Groups = append([]string(nil), file.Groups...)
+		clone.Files[i].Segments = append([]Segment(nil), file.Segments...)
+	}
+
+	return clone.Normalize()
+}
+
+// Normalize fixes what it can in the NZB and drops the files it can't fix,
+// returning the problems it found. Segments are sorted by number, and
+// segments repeating a number or message-id are dropped.
+func (n *NZB) Normalize() []Problem {
+	var problems []Problem
+	report := func(file *File, fatal bool, format string, args ...interface{}) {
+		problems = append(problems, Problem{Subject: file.Subject, Message: fmt.Sprintf(format, args...), Fatal: fatal})
+	}
+
+	// Articles are fetched by message-id, so any group the post went to
+	// will do for a file that lists none.
+	var groups []string
+	for _, file := range n.Files {
+		if len(file.Groups) > 0 {
+			groups = file.Groups
+			break
+		}
+	}
+
+	seen := make(map[string]bool)
+	var files []File
+	for _, file := range n.Files {
+		if len(file.Groups) == 0 && len(groups) > 0 {
+			report(&file, false, "no groups, using %v", groups)
+			file.Groups = groups
+		} else if len(file.Groups) == 0 {
+			report(&file, false, "no groups in the NZB, fetching by message-id alone")
+		}
+
+		file.Segments = normalizeSegments(&file, seen, report)
+		if len(file.Segments) == 0 {
+			report(&file, true, "no segments")
+			continue
+		}
+
+		files = append(files, file)
+	}
+	n.Files = files
+
+	return problems
+}
+
+func normalizeSegments(file *File, seen map[string]bool, report func(*File, bool, string, ...interface{})) []Segment {
+	sort.SliceStable(file.Segments, func(i, j int) bool { return file.Segments[i].Number < file.Segments[j].Number })
+
+	var segments []Segment
+	numbers := make(map[int]bool)
+	total := int64(0)
+	for _, segment := range file.Segments {
+		id := strings.TrimSpace(segment.Segment)
+		if strings.HasPrefix(id, "<") && strings.HasSuffix(id, ">") {
+			id = id[1 : len(id)-1]
+		}
+		segment.Segment = id
+
+		switch {
+		case id == "":
+			report(file, false, "segment %d has no message-id", segment.Number)
+			continue
+		case numbers[segment.Number]:
+			report(file, false, "segment %d repeated", segment.Number)
+			continue
+		case seen[id]:
+			report(file, false, "segment %d repeats message-id %s", segment.Number, id)
+			continue
+		}
+
+		numbers[segment.Number] = true
+		seen[id] = true
+		total += segment.Bytes
+		segments = append(segments, segment)
+	}
+
+	if len(segments) == 0 {
+		return nil
+	}
+
+	// Guess the size of segments without one from the others, since sizes
+	// drive progress and memory use.
+	sized := 0
+	for _, segment := range segments {
+		if segment.Bytes > 0 {
+			sized++
+		}
+	}
+	if sized < len(segments) {
+		guess := defaultArticleSize
+		if sized > 0 {
+			guess = total / int64(sized)
+			report(file, false, "%d segments have no size", len(segments)-sized)
+		} else {
+			report(file, false, "no segment sizes, assuming %v each", ByteSize(guess))
+		}
+
+		for i := range segments {
+			if segments[i].Bytes <= 0 {
+				segments[i].Bytes = guess
+			}
+		}
+	}
+
+	if gaps := segmentGaps(segments, file.ParsedSubject().Segments); len(gaps) > maxGaps {
+		report(file, false, "missing %d segments %v...", len(gaps), gaps[:maxGaps])
+	} else if len(gaps) > 0 {
+		report(file, false, "missing segments %v", gaps)
+	}
+
+	return segments
+}
+
+// Returns the numbers missing from the sorted segments, counting up to
+// total if the subject gives one.
+func segmentGaps(segments []Segment, total int) []int {
+	last := segments[len(segments)-1].Number
+	if total > last {
+		last = total
+	}
+
+	var gaps []int
+	next := 1
+	for _, segment := range segments {
+		for ; next < segment.Number; next++ {
+			gaps = append(gaps, next)
+		}
+		if segment.Number >= next {
+			next = segment.Number + 1
+		}
+	}
+	for ; next <= last; next++ {
+		gaps = append(gaps, next)
+	}
+
+	return gaps
+}
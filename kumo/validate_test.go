@@ -0,0 +1,89 @@
+package kumo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	nzb := &NZB{Files: []File{
+		{
+			Subject: `"a.rar" yEnc (1/5)`,
+			Groups:  []string{"alt.a"},
+			Segments: []Segment{
+				{Bytes: 10, Number: 3, Segment: "3@a"},
+				{Bytes: 10, Number: 1, Segment: "<1@a>"},
+				{Bytes: 10, Number: 1, Segment: "1@a-again"},
+				{Bytes: 0, Number: 2, Segment: "2@a"},
+			},
+		},
+		{
+			Subject:  `"b.rar" yEnc (1/1)`,
+			Segments: []Segment{{Bytes: 10, Number: 1, Segment: "3@a"}},
+		},
+		{
+			Subject:  `"c.rar" yEnc (1/1)`,
+			Segments: []Segment{{Bytes: 7, Number: 1, Segment: "1@c"}},
+		},
+	}}
+
+	if problems := nzb.Validate(); len(problems) != 7 || len(nzb.Files) != 3 || len(nzb.Files[0].Segments) != 4 {
+		t.Errorf("Validate() returned %v and changed the NZB to %+v", problems, nzb)
+	}
+
+	problems := nzb.Normalize()
+
+	var fatal []string
+	for _, problem := range problems {
+		if problem.Fatal {
+			fatal = append(fatal, problem.Subject)
+		}
+	}
+	if len(problems) != 7 || !reflect.DeepEqual(fatal, []string{`"b.rar" yEnc (1/1)`}) {
+		t.Errorf("Normalize() returned %v", problems)
+	}
+
+	want := []File{
+		{
+			Subject: `"a.rar" yEnc (1/5)`,
+			Groups:  []string{"alt.a"},
+			Segments: []Segment{
+				{Bytes: 10, Number: 1, Segment: "1@a"},
+				{Bytes: 10, Number: 2, Segment: "2@a"},
+				{Bytes: 10, Number: 3, Segment: "3@a"},
+			},
+		},
+		{
+			Subject:  `"c.rar" yEnc (1/1)`,
+			Groups:   []string{"alt.a"},
+			Segments: []Segment{{Bytes: 7, Number: 1, Segment: "1@c"}},
+		},
+	}
+	if !reflect.DeepEqual(nzb.Files, want) {
+		t.Errorf("Normalize() left %+v, want %+v", nzb.Files, want)
+	}
+}
+
+func TestNormalizeNoGroupsOrSizes(t *testing.T) {
+	nzb := &NZB{Files: []File{{
+		Subject:  `"a.rar" yEnc (1/2)`,
+		Segments: []Segment{{Number: 1, Segment: "1@a"}, {Number: 2, Segment: "2@a"}},
+	}}}
+
+	problems := nzb.Normalize()
+	if len(problems) != 2 {
+		t.Errorf("Normalize() returned %v, want a problem for groups and sizes", problems)
+	}
+	for _, segment := range nzb.Files[0].Segments {
+		if segment.Bytes != defaultArticleSize {
+			t.Errorf("Normalize() sized segment %d at %d, want %d", segment.Number, segment.Bytes, defaultArticleSize)
+		}
+	}
+}
+
+func TestSegmentGaps(t *testing.T) {
+	segments := []Segment{{Number: 2}, {Number: 3}, {Number: 6}}
+	if gaps := segmentGaps(segments, 7); !reflect.DeepEqual(gaps, []int{1, 4, 5, 7}) {
+		t.Errorf("segmentGaps() = %v", gaps)
+	}
+}
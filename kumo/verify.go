@@ -0,0 +1,175 @@
+package kumo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sww/kumo/par2"
+)
+
+var par2Magic = []byte("PAR2\x00PKT")
+
+// Returns the paths of the PAR2 files in dir. Files are recognised by the
+// packet header they start with, so obfuscated names are found too.
+func par2Files(dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() && (strings.EqualFold(filepath.Ext(entry.Name()), ".par2") || isPAR2File(path)) {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+func isPAR2File(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, len(par2Magic))
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+
+	return bytes.Equal(header, par2Magic)
+}
+
+// Reads the recovery set from the PAR2 files in dir, or returns nil if
+// there are none.
+func (k *Kumo) readPAR2(dir string) *par2.Set {
+	paths := par2Files(dir)
+	if len(paths) == 0 {
+		return nil
+	}
+
+	set := par2.NewSet()
+	for _, path := range paths {
+		if err := set.ReadFile(path); err != nil {
+			k.logger.Printf("[PAR2] Error reading %v: %v", path, err)
+		}
+	}
+
+	return set
+}
+
+// Renames files in dir that match a file in the recovery set under another
+// name to their real names.
+func (k *Kumo) deobfuscate(set *par2.Set, dir string) {
+	renames, err := set.Match(dir)
+	if err != nil {
+		k.logger.Printf("[PAR2] Error matching files: %v", err)
+		return
+	}
+
+	// Move the files out of the way first, so names can be swapped around.
+	temps := make(map[string]string)
+	for name, real := range renames {
+		if _, moving := renames[real]; !moving && exists(filepath.Join(dir, real)) {
+			k.logger.Printf("[PAR2] Not renaming %v, %v already exists", name, real)
+			continue
+		}
+
+		temp := filepath.Join(dir, ".kumo-rename-"+name)
+		if err := os.Rename(filepath.Join(dir, name), temp); err != nil {
+			k.logger.Printf("[PAR2] Error renaming %v: %v", name, err)
+			continue
+		}
+		temps[name] = temp
+	}
+
+	for name, temp := range temps {
+		real := renames[name]
+		if err := os.Rename(temp, filepath.Join(dir, real)); err != nil {
+			k.logger.Printf("[PAR2] Error renaming %v to %v: %v", name, real, err)
+			os.Rename(temp, filepath.Join(dir, name))
+			continue
+		}
+		k.logger.Printf("[PAR2] Renamed %v to %v", name, real)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Verifies the joined files in dir against the recovery set.
+func (k *Kumo) verify(set *par2.Set, dir string) (*par2.Result, error) {
+	result, err := set.Verify(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range result.Files {
+		switch {
+		case file.Missing:
+			k.logger.Printf("[PAR2] %v is missing", file.Name)
+		case !file.OK():
+			k.logger.Printf("[PAR2] %v has %d/%d damaged slices: %v", file.Name, len(file.Damaged), file.Slices, file.Damaged)
+		}
+	}
+
+	if !k.config.Quiet {
+		printVerify(result)
+	}
+
+	return result, nil
+}
+
+// Repairs the damaged slices in result with the recovery slices in set.
+func (k *Kumo) repair(set *par2.Set, dir string, result *par2.Result) (*par2.RepairResult, error) {
+	k.logger.Printf("[PAR2] Repairing %d damaged slices with %d recovery slices", result.DamagedSlices(), set.RecoveryBlocks)
+
+	repair, err := set.Repair(dir, result)
+	if err != nil {
+		return nil, err
+	}
+
+	k.logger.Printf("[PAR2] Repaired %d slices, %d unrepairable", repair.Repaired, repair.Unrepairable)
+
+	if !k.config.Quiet {
+		printRepair(repair)
+	}
+
+	return repair, nil
+}
+
+func printRepair(repair *par2.RepairResult) {
+	switch {
+	case repair.Needed > 0:
+		fmt.Printf("%s Can't repair, need %d more recovery slices\n", red(PREFIX_COMPLETE_BROKEN), repair.Needed)
+	case repair.Unrepairable > 0:
+		fmt.Printf("%s Repaired %d slices, %d unrepairable\n", red(PREFIX_COMPLETE_BROKEN), repair.Repaired, repair.Unrepairable)
+	default:
+		fmt.Printf("%s Repaired %d slices\n", green(PREFIX_COMPLETE_OK), repair.Repaired)
+	}
+}
+
+func printVerify(result *par2.Result) {
+	if result.OK() {
+		fmt.Printf("%s Verified %d files\n", green(PREFIX_COMPLETE_OK), len(result.Files))
+		return
+	}
+
+	for _, file := range result.Files {
+		if file.Missing {
+			fmt.Printf("%s %s is missing\n", red(PREFIX_COMPLETE_BROKEN), file.Name)
+		} else if !file.OK() {
+			fmt.Printf("%s %s has %d/%d damaged slices\n", red(PREFIX_COMPLETE_BROKEN), file.Name, len(file.Damaged), file.Slices)
+		}
+	}
+}
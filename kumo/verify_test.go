@@ -0,0 +1,22 @@
+package kumo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_par2Files(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "set.par2"), []byte("PAR2\x00PKT index"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b7e0f1"), []byte("PAR2\x00PKT obfuscated volume"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "movie.mkv"), []byte("not a par2 file"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "tiny"), []byte("PAR"), 0644)
+
+	got := par2Files(dir)
+	want := []string{filepath.Join(dir, "b7e0f1"), filepath.Join(dir, "set.par2")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("par2Files() = %v, want %v", got, want)
+	}
+}
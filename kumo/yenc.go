@@ -0,0 +1,121 @@
+package kumo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNoYencHeader  = errors.New("yenc: no =ybegin line")
+	errNoYencTrailer = errors.New("yenc: no =yend line")
+)
+
+// Decodes the yEnc encoded article in data, appending the decoded bytes to
+// out, which the returned part's Body points into.
+func decodeYenc(data []byte, out *bytes.Buffer) (*DecodedPart, error) {
+	part := new(DecodedPart)
+
+	line, data := nextLine(data)
+	for !bytes.HasPrefix(line, []byte("=ybegin ")) {
+		if len(data) == 0 {
+			return nil, errNoYencHeader
+		}
+		line, data = nextLine(data)
+	}
+
+	header := yencFields(line)
+	part.Name = header["name"]
+	part.Part, _ = strconv.Atoi(header["part"])
+	part.Total, _ = strconv.Atoi(header["total"])
+	part.Size, _ = strconv.ParseInt(header["size"], 10, 64)
+	part.BeginSize = 1
+	part.EndSize = part.Size
+
+	if bytes.HasPrefix(data, []byte("=ypart ")) {
+		line, data = nextLine(data)
+		fields := yencFields(line)
+		part.BeginSize, _ = strconv.ParseInt(fields["begin"], 10, 64)
+		part.EndSize, _ = strconv.ParseInt(fields["end"], 10, 64)
+	}
+
+	// The header comes from the server, so check it before trusting it
+	// with an allocation. Escaping only ever makes the body longer.
+	length := part.EndSize - part.BeginSize + 1
+	if part.BeginSize < 1 || part.EndSize < part.BeginSize || part.EndSize > part.Size || length > int64(len(data)) {
+		return nil, fmt.Errorf("yenc: bad part range %d-%d of %d bytes in %q", part.BeginSize, part.EndSize, part.Size, part.Name)
+	}
+
+	start := out.Len()
+	out.Grow(int(length))
+
+	for {
+		if len(data) == 0 {
+			return nil, errNoYencTrailer
+		}
+
+		line, data = nextLine(data)
+		if bytes.HasPrefix(line, []byte("=yend")) {
+			trailer := yencFields(line)
+			part.CRC32 = trailer["pcrc32"]
+			if part.CRC32 == "" {
+				part.CRC32 = trailer["crc32"]
+			}
+			break
+		}
+
+		escaped := false
+		for _, b := range line {
+			switch {
+			case escaped:
+				out.WriteByte(b - 64 - 42)
+				escaped = false
+			case b == '=':
+				escaped = true
+			case b == '\r':
+			default:
+				out.WriteByte(b - 42)
+			}
+		}
+	}
+
+	part.Body = out.Bytes()[start:]
+
+	if size := int64(len(part.Body)); size != part.EndSize-part.BeginSize+1 {
+		return part, fmt.Errorf("yenc: decoded %d bytes of %q, expected %d", size, part.Name, part.EndSize-part.BeginSize+1)
+	}
+
+	return part, nil
+}
+
+// Returns the first line of data, without its line ending, and the rest.
+func nextLine(data []byte) ([]byte, []byte) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return bytes.TrimSuffix(data, []byte("\r")), nil
+	}
+
+	return bytes.TrimSuffix(data[:i], []byte("\r")), data[i+1:]
+}
+
+// Parses the key=value pairs of a =ybegin, =ypart or =yend line. The name
+// key is always last and runs to the end of the line, spaces included.
+func yencFields(line []byte) map[string]string {
+	fields := make(map[string]string)
+	s := string(line)
+
+	if i := strings.Index(s, " name="); i >= 0 {
+		fields["name"] = strings.TrimSpace(s[i+len(" name="):])
+		s = s[:i]
+	}
+
+	for _, field := range strings.Fields(s)[1:] {
+		if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,72 @@
+package kumo
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+// Encodes data as one yEnc part of a file of size bytes, beginning at the
+// 1-based offset begin.
+func encodeYenc(name string, part int, size, begin int64, data []byte) []byte {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "=ybegin part=%d line=128 size=%d name=%s\n", part, size, name)
+	fmt.Fprintf(&out, "=ypart begin=%d end=%d\n", begin, begin+int64(len(data))-1)
+	column := 0
+	for _, b := range data {
+		b += 42
+		switch b {
+		case 0, '\n', '\r', '=':
+			out.WriteByte('=')
+			b += 64
+		}
+		out.WriteByte(b)
+		if column++; column == 128 {
+			out.WriteByte('\n')
+			column = 0
+		}
+	}
+	fmt.Fprintf(&out, "\n=yend size=%d part=%d pcrc32=%08x\n", len(data), part, crc32.ChecksumIEEE(data))
+
+	return out.Bytes()
+}
+
+func Test_decodeYenc(t *testing.T) {
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	encoded := encodeYenc("some file.bin", 2, 1000, 301, data)
+
+	part, err := decodeYenc(append([]byte("header junk\n"), encoded...), new(bytes.Buffer))
+	if err != nil {
+		t.Fatalf("decodeYenc() returned %v", err)
+	}
+
+	if part.Name != "some file.bin" || part.Part != 2 || part.Size != 1000 || part.BeginSize != 301 || part.EndSize != 600 {
+		t.Errorf("Returned %+v", part)
+	}
+	if !bytes.Equal(part.Body, data) {
+		t.Errorf("Returned body %v, want %v", part.Body, data)
+	}
+	if !checksum(part.Body, part.CRC32) {
+		t.Errorf("Returned CRC32 %v that does not match body", part.CRC32)
+	}
+}
+
+func Test_decodeYencBadHeader(t *testing.T) {
+	body := "\n" + string(bytes.Repeat([]byte("a"), 500)) + "\n=yend size=500 part=2\n"
+	tests := map[string]string{
+		"missing end":    "=ybegin part=2 line=128 size=1000 name=a\n=ypart begin=501\n",
+		"end < begin":    "=ybegin part=2 line=128 size=1000 name=a\n=ypart begin=501 end=400\n",
+		"oversized size": "=ybegin line=128 size=99999999999 name=a",
+	}
+
+	for name, header := range tests {
+		if _, err := decodeYenc([]byte(header+body), new(bytes.Buffer)); err == nil {
+			t.Errorf("decodeYenc() with %s returned no error", name)
+		}
+	}
+}
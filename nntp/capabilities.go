@@ -0,0 +1,148 @@
+package nntp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Capabilities is the server's response to CAPABILITIES (RFC 3977 5.2).
+// A server that doesn't understand CAPABILITIES has no capabilities and a
+// Version of 0.
+type Capabilities struct {
+	Version int
+	caps    map[string][]string
+}
+
+func parseCapabilities(lines []string) *Capabilities {
+	c := &Capabilities{caps: make(map[string][]string)}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		keyword := strings.ToUpper(fields[0])
+		c.caps[keyword] = fields[1:]
+
+		if keyword == "VERSION" {
+			for _, version := range fields[1:] {
+				if v, err := strconv.Atoi(version); err == nil && v > c.Version {
+					c.Version = v
+				}
+			}
+		}
+	}
+
+	return c
+}
+
+// Has reports whether the server advertised keyword.
+func (c *Capabilities) Has(keyword string) bool {
+	_, ok := c.caps[strings.ToUpper(keyword)]
+	return ok
+}
+
+// Args returns the arguments advertised with keyword.
+func (c *Capabilities) Args(keyword string) []string {
+	return c.caps[strings.ToUpper(keyword)]
+}
+
+// HasArg reports whether the server advertised keyword with arg.
+func (c *Capabilities) HasArg(keyword, arg string) bool {
+	for _, a := range c.Args(keyword) {
+		if strings.EqualFold(a, arg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NeedsModeReader reports whether MODE READER must be sent before reading
+// articles. Servers without CAPABILITIES are sent it just in case.
+func (c *Capabilities) NeedsModeReader() bool {
+	if c.Version == 0 {
+		return true
+	}
+
+	return c.Has("MODE-READER") && !c.Has("READER")
+}
+
+func (c *Capabilities) StartTLS() bool {
+	return c.Has("STARTTLS")
+}
+
+// Returns the compression to use with Compress, preferring COMPRESS
+// DEFLATE when advertised.
+func (c *Capabilities) compression() compression {
+	if c.HasArg("COMPRESS", "DEFLATE") {
+		return compressDeflate
+	}
+
+	return compressGzip
+}
+
+// Pipelining reports whether several commands may be sent before reading
+// their responses. RFC 3977 servers must allow it; older servers are not
+// trusted to.
+func (c *Capabilities) Pipelining() bool {
+	return c.Version >= 2
+}
+
+func (n *NNTP) Capabilities() *Capabilities {
+	return n.caps
+}
+
+// Fetches the server's capabilities, treating a server that doesn't know
+// the command as having none.
+func (n *NNTP) refreshCapabilities() error {
+	lines, err := n.capabilities()
+	if err != nil {
+		var nntpErr *Error
+		if !errors.As(err, &nntpErr) {
+			return err
+		}
+		lines = nil
+	}
+
+	n.caps = parseCapabilities(lines)
+
+	return nil
+}
+
+func (n *NNTP) capabilities() ([]string, error) {
+	if err := n.conn.PrintfLine("CAPABILITIES"); err != nil {
+		return nil, wrapError(err)
+	}
+
+	if _, _, err := n.conn.ReadCodeLine(101); err != nil {
+		return nil, wrapError(err)
+	}
+
+	lines, err := n.conn.ReadDotLines()
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return lines, nil
+}
+
+// Switches the server to reader mode, then fetches the capabilities again
+// since they usually change.
+func (n *NNTP) modeReader() error {
+	if err := n.conn.PrintfLine("MODE READER"); err != nil {
+		return wrapError(err)
+	}
+
+	if _, _, err := n.conn.ReadCodeLine(20); err != nil {
+		err = wrapError(err)
+		// Servers from before RFC 3977 may not know the command.
+		if IsConnError(err) || n.caps.Version > 0 {
+			return err
+		}
+		return nil
+	}
+
+	return n.refreshCapabilities()
+}
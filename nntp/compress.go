@@ -0,0 +1,223 @@
+package nntp
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"sync/atomic"
+)
+
+type compression int
+
+const (
+	compressNone compression = iota
+	compressDeflate
+	compressGzip
+)
+
+var ErrCompressionUnsupported = errors.New("nntp: server does not support compression")
+
+// Stats counts the bytes read from the server before and after
+// decompression.
+type Stats struct {
+	CompressedBytes   int64
+	UncompressedBytes int64
+}
+
+// Ratio returns compressed bytes over uncompressed bytes, or 1 if nothing
+// compressed has been read.
+func (s Stats) Ratio() float64 {
+	if s.UncompressedBytes == 0 {
+		return 1
+	}
+
+	return float64(s.CompressedBytes) / float64(s.UncompressedBytes)
+}
+
+func (n *NNTP) Stats() Stats {
+	return Stats{
+		CompressedBytes:   atomic.LoadInt64(&n.stats.CompressedBytes),
+		UncompressedBytes: atomic.LoadInt64(&n.stats.UncompressedBytes),
+	}
+}
+
+// Compress turns on compression, preferring RFC 8054 COMPRESS DEFLATE when
+// the server advertises it and falling back to XFEATURE COMPRESS GZIP.
+// It returns ErrCompressionUnsupported if the server supports neither.
+func (n *NNTP) Compress() error {
+	if n.caps.compression() == compressDeflate {
+		return n.compressDeflate()
+	}
+
+	return n.compressGzip()
+}
+
+// Switches the whole connection, in both directions, to a raw deflate
+// stream as described in RFC 8054.
+func (n *NNTP) compressDeflate() error {
+	if err := n.conn.PrintfLine("COMPRESS DEFLATE"); err != nil {
+		return wrapError(err)
+	}
+
+	if _, _, err := n.conn.ReadCodeLine(206); err != nil {
+		return wrapError(err)
+	}
+
+	// Anything the server sent after the 206 response may already be
+	// buffered, so decompress from the existing reader.
+	compressed := &countingReader{r: n.conn.R, count: &n.stats.CompressedBytes}
+	writer, err := flate.NewWriter(n.raw, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+
+	n.conn = textproto.NewConn(&deflateConn{
+		Reader: &countingReader{r: flate.NewReader(compressed), count: &n.stats.UncompressedBytes},
+		writer: writer,
+		closer: n.raw,
+	})
+	n.compress = compressDeflate
+
+	return nil
+}
+
+// Asks the server to compress multi-line responses, each one as a separate
+// zlib stream followed by a terminating line.
+func (n *NNTP) compressGzip() error {
+	if err := n.conn.PrintfLine("XFEATURE COMPRESS GZIP TERMINATOR"); err != nil {
+		return wrapError(err)
+	}
+
+	_, _, err := n.conn.ReadCodeLine(290)
+	if err != nil {
+		err = wrapError(err)
+		if IsConnError(err) {
+			return err
+		}
+		return ErrCompressionUnsupported
+	}
+
+	n.compress = compressGzip
+
+	return nil
+}
+
+// Returns a reader for the data of a multi-line response, decompressing it
+// if the server sent it compressed.
+func (n *NNTP) multiline() io.Reader {
+	if n.compress != compressGzip || !n.peekZlib() {
+		return n.conn.DotReader()
+	}
+
+	zr, err := zlib.NewReader(&countingReader{r: n.conn.R, count: &n.stats.CompressedBytes})
+	if err != nil {
+		return errReader{err}
+	}
+
+	decompressed := &countingReader{r: zr, count: &n.stats.UncompressedBytes}
+	return &gzipBody{
+		n:   n,
+		z:   zr,
+		dot: textproto.NewReader(bufio.NewReader(decompressed)).DotReader(),
+	}
+}
+
+// Returns whether the next bytes from the server are a zlib header.
+func (n *NNTP) peekZlib() bool {
+	header, err := n.conn.R.Peek(2)
+	if err != nil {
+		return false
+	}
+
+	return header[0]&0x0f == 8 && header[0]>>4 <= 7 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+type gzipBody struct {
+	n   *NNTP
+	z   io.ReadCloser
+	dot io.Reader
+}
+
+func (g *gzipBody) Read(p []byte) (int, error) {
+	count, err := g.dot.Read(p)
+	if err != io.EOF {
+		return count, err
+	}
+
+	// Finish the zlib stream so its checksum is verified, then consume the
+	// terminator that follows it.
+	if _, err := io.Copy(ioutil.Discard, g.z); err != nil {
+		return count, err
+	}
+	if err := g.z.Close(); err != nil {
+		return count, err
+	}
+
+	line, err := g.n.conn.ReadLine()
+	if err != nil {
+		return count, err
+	}
+	if line != "." {
+		return count, textproto.ProtocolError("expected terminator after compressed response, got " + line)
+	}
+
+	return count, io.EOF
+}
+
+type deflateConn struct {
+	io.Reader
+	writer *flate.Writer
+	closer io.Closer
+}
+
+func (d *deflateConn) Write(p []byte) (int, error) {
+	count, err := d.writer.Write(p)
+	if err != nil {
+		return count, err
+	}
+
+	return count, d.writer.Flush()
+}
+
+func (d *deflateConn) Close() error {
+	return d.closer.Close()
+}
+
+type countingReader struct {
+	r     io.Reader
+	count *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	count, err := c.r.Read(p)
+	atomic.AddInt64(c.count, int64(count))
+	return count, err
+}
+
+// ReadByte lets flate read exactly up to the end of a compressed stream
+// instead of buffering past it.
+func (c *countingReader) ReadByte() (byte, error) {
+	if br, ok := c.r.(io.ByteReader); ok {
+		b, err := br.ReadByte()
+		if err == nil {
+			atomic.AddInt64(c.count, 1)
+		}
+		return b, err
+	}
+
+	var b [1]byte
+	_, err := io.ReadFull(c, b[:])
+	return b[0], err
+}
+
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read(p []byte) (int, error) {
+	return 0, e.err
+}
@@ -0,0 +1,82 @@
+package nntp
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+)
+
+// Error is a response from the server with an unexpected status code.
+type Error struct {
+	Code int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%03d %s", e.Code, e.Msg)
+}
+
+// Is reports whether target is an *Error with the same response code, so
+// errors.Is(err, ErrNoSuchArticle) matches any 430 response.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Temporary reports whether the same command may succeed if retried later.
+func (e *Error) Temporary() bool {
+	switch e.Code {
+	case 400, 403, 480:
+		return true
+	}
+
+	return false
+}
+
+var (
+	ErrServiceUnavailable = &Error{Code: 400, Msg: "service not available"}
+	ErrInternalFault      = &Error{Code: 403, Msg: "internal fault"}
+	ErrNoSuchGroup        = &Error{Code: 411, Msg: "no such newsgroup"}
+	ErrNoSuchArticleNum   = &Error{Code: 423, Msg: "no article with that number"}
+	ErrNoSuchArticle      = &Error{Code: 430, Msg: "no such article"}
+	ErrAuthRequired       = &Error{Code: 480, Msg: "authentication required"}
+	ErrAuthRejected       = &Error{Code: 481, Msg: "authentication failed"}
+	ErrAuthOutOfSequence  = &Error{Code: 482, Msg: "authentication commands out of sequence"}
+	ErrUnknownCommand     = &Error{Code: 500, Msg: "unknown command"}
+	ErrSyntax             = &Error{Code: 501, Msg: "syntax error"}
+	ErrAccessDenied       = &Error{Code: 502, Msg: "service permanently unavailable"}
+)
+
+// ConnError is a failure reading from or writing to the connection itself,
+// after which the connection should not be reused.
+type ConnError struct {
+	Err error
+}
+
+func (e *ConnError) Error() string {
+	return "nntp: connection error: " + e.Err.Error()
+}
+
+func (e *ConnError) Unwrap() error {
+	return e.Err
+}
+
+// IsConnError reports whether err means the connection is no longer usable.
+func IsConnError(err error) bool {
+	var connErr *ConnError
+	return errors.As(err, &connErr)
+}
+
+// Converts errors from textproto into *Error and *ConnError values.
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return &Error{Code: protoErr.Code, Msg: protoErr.Msg}
+	}
+
+	return &ConnError{Err: err}
+}
@@ -2,68 +2,114 @@ package nntp
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"io"
+	"net"
 	"net/textproto"
 )
 
-func New(net, addr string, ssl bool) (*NNTP, error) {
-	var conn *textproto.Conn
+// Security selects how a connection is encrypted.
+type Security int
+
+const (
+	// SecurityNone is a plain TCP connection.
+	SecurityNone Security = iota
+	// SecurityTLS is implicit TLS, usually on port 563.
+	SecurityTLS
+	// SecurityStartTLS connects in plaintext and upgrades to TLS with the
+	// STARTTLS command (RFC 4642) before authenticating.
+	SecurityStartTLS
+)
+
+// New connects to addr. tlsConfig may be nil to use the default TLS
+// settings, and is ignored for SecurityNone.
+func New(network, addr string, security Security, tlsConfig *TLSConfig) (*NNTP, error) {
+	var conn net.Conn
 	var err error
-	if ssl {
-		certPool, err := x509.SystemCertPool()
+	if security == SecurityTLS {
+		config, err := tlsConfig.config(addr)
 		if err != nil {
 			return nil, err
 		}
 
-		config := tls.Config{RootCAs: certPool}
-
-		tlsConn, err := tls.Dial(net, addr, &config)
+		conn, err = tls.Dial(network, addr, config)
 		if err != nil {
-			return nil, err
+			return nil, &ConnError{Err: err}
 		}
-
-		conn = textproto.NewConn(tlsConn)
 	} else {
-		conn, err = textproto.Dial(net, addr)
+		conn, err = net.Dial(network, addr)
 		if err != nil {
-			return nil, err
+			return nil, &ConnError{Err: err}
 		}
 	}
 
-	_, _, err = conn.ReadCodeLine(200)
+	nntp := NNTP{
+		raw:  conn,
+		conn: textproto.NewConn(conn),
+	}
+
+	// 200 or 201, depending on whether posting is allowed.
+	_, _, err = nntp.conn.ReadCodeLine(20)
 	if err != nil {
+		nntp.Close()
+		return nil, wrapError(err)
+	}
+
+	if err := nntp.refreshCapabilities(); err != nil {
+		nntp.Close()
 		return nil, err
 	}
 
-	nntp := NNTP{
-		conn: conn,
+	if nntp.caps.NeedsModeReader() {
+		if err := nntp.modeReader(); err != nil {
+			nntp.Close()
+			return nil, err
+		}
+	}
+
+	if security == SecurityStartTLS {
+		if err := nntp.startTLS(addr, tlsConfig); err != nil {
+			nntp.Close()
+			return nil, err
+		}
 	}
 
 	return &nntp, nil
 }
 
 type NNTP struct {
-	conn *textproto.Conn
+	raw      net.Conn
+	conn     *textproto.Conn
+	caps     *Capabilities
+	compress compression
+	stats    Stats
+}
+
+func (n *NNTP) Close() error {
+	return n.conn.Close()
 }
 
 func (n *NNTP) Auth(user, password string) (string, error) {
 	if err := n.conn.PrintfLine("authinfo user %s", user); err != nil {
-		return "", err
+		return "", wrapError(err)
 	}
 
 	_, msg, err := n.conn.ReadCodeLine(381)
 	if err != nil {
-		return "", err
+		return "", wrapError(err)
 	}
 
 	err = n.conn.PrintfLine("authinfo pass %s", password)
 	if err != nil {
-		return "", err
+		return "", wrapError(err)
 	}
 
 	_, msg, err = n.conn.ReadCodeLine(281)
 	if err != nil {
+		return "", wrapError(err)
+	}
+
+	// Servers may advertise more once authenticated.
+	if err := n.refreshCapabilities(); err != nil {
 		return "", err
 	}
 
@@ -71,14 +117,23 @@ func (n *NNTP) Auth(user, password string) (string, error) {
 }
 
 func (n *NNTP) Group(group string) (string, error) {
-	err := n.conn.PrintfLine("GROUP %s", group)
-	if err != nil {
+	if err := n.SendGroup(group); err != nil {
 		return "", err
 	}
 
+	return n.ReadGroup()
+}
+
+// SendGroup sends a GROUP command without waiting for the response, which
+// must be read later with ReadGroup.
+func (n *NNTP) SendGroup(group string) error {
+	return wrapError(n.conn.PrintfLine("GROUP %s", group))
+}
+
+func (n *NNTP) ReadGroup() (string, error) {
 	_, msg, err := n.conn.ReadCodeLine(211)
 	if err != nil {
-		return "", err
+		return "", wrapError(err)
 	}
 
 	return msg, nil
@@ -87,29 +142,115 @@ func (n *NNTP) Group(group string) (string, error) {
 func (n *NNTP) Article(id string) (string, error) {
 	err := n.conn.PrintfLine("ARTICLE %s", id)
 	if err != nil {
-		return "", err
+		return "", wrapError(err)
 	}
 
 	_, msg, err := n.conn.ReadCodeLine(220)
 	if err != nil {
-		return "", err
+		return "", wrapError(err)
 	}
 
 	return msg, nil
 }
 
 func (n *NNTP) Body(id string) (int, string, io.Reader, error) {
-	err := n.conn.PrintfLine("BODY %s", id)
-	if err != nil {
+	if err := n.SendBody(id); err != nil {
 		return 0, "", nil, err
 	}
 
+	return n.ReadBody()
+}
+
+// SendBody sends a BODY command without waiting for the response, so that
+// several can be in flight on one connection. Responses must be read back
+// with ReadBody in the order the commands were sent, and each body must be
+// read to EOF before reading the next response.
+func (n *NNTP) SendBody(id string) error {
+	return wrapError(n.conn.PrintfLine("BODY %s", id))
+}
+
+func (n *NNTP) ReadBody() (int, string, io.Reader, error) {
 	code, msg, err := n.conn.ReadCodeLine(22)
 	if err != nil {
-		return 0, "", nil, err
+		return 0, "", nil, wrapError(err)
 	}
 
-	reader := n.conn.DotReader()
+	reader := &bodyReader{n.multiline()}
 
 	return code, msg, reader, nil
 }
+
+func (n *NNTP) Stat(id string) (string, error) {
+	err := n.conn.PrintfLine("STAT %s", id)
+	if err != nil {
+		return "", wrapError(err)
+	}
+
+	_, msg, err := n.conn.ReadCodeLine(223)
+	if err != nil {
+		return "", wrapError(err)
+	}
+
+	return msg, nil
+}
+
+// StatAll pipelines a STAT command for each id, sending every command before
+// reading the responses back in order. The returned slice holds the error,
+// if any, for each id. The second return value is set if the connection
+// failed part way through.
+func (n *NNTP) StatAll(ids []string) ([]error, error) {
+	if !n.caps.Pipelining() {
+		return n.statEach(ids)
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		for _, id := range ids {
+			if err := n.conn.PrintfLine("STAT %s", id); err != nil {
+				written <- wrapError(err)
+				return
+			}
+		}
+		written <- nil
+	}()
+
+	errs := make([]error, len(ids))
+	for i := range ids {
+		_, _, err := n.conn.ReadCodeLine(223)
+		err = wrapError(err)
+		if IsConnError(err) {
+			return errs, err
+		}
+		errs[i] = err
+	}
+
+	return errs, <-written
+}
+
+// Sends STAT for each id in turn, for servers that may not allow pipelining.
+func (n *NNTP) statEach(ids []string) ([]error, error) {
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		_, err := n.Stat(id)
+		if IsConnError(err) {
+			return errs, err
+		}
+		errs[i] = err
+	}
+
+	return errs, nil
+}
+
+// Wraps read errors from a response body as *ConnError.
+type bodyReader struct {
+	r io.Reader
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &ConnError{Err: err}
+	}
+
+	return n, err
+}
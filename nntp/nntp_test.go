@@ -0,0 +1,289 @@
+package nntp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"reflect"
+	"testing"
+)
+
+// Returns an NNTP client connected to a fake server that answers each
+// command with the next line from responses.
+func fakeServer(t *testing.T, responses ...string) *NNTP {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	go func() {
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		for _, response := range responses {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+			if _, err := server.Write([]byte(response + "\r\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	return &NNTP{
+		conn: textproto.NewConn(client),
+		caps: parseCapabilities([]string{"VERSION 2", "READER"}),
+	}
+}
+
+func TestBodyNoSuchArticle(t *testing.T) {
+	n := fakeServer(t, "430 No Such Article")
+
+	_, _, _, err := n.Body("<1@foo.com>")
+	if !errors.Is(err, ErrNoSuchArticle) {
+		t.Fatalf("Body() returned %v, want %v", err, ErrNoSuchArticle)
+	}
+
+	var nntpErr *Error
+	if !errors.As(err, &nntpErr) || nntpErr.Msg != "No Such Article" {
+		t.Errorf("Body() returned %#v, want message %q", err, "No Such Article")
+	}
+}
+
+func TestAuthRejected(t *testing.T) {
+	n := fakeServer(t, "381 Password required", "481 Authentication failed")
+
+	_, err := n.Auth("user", "pass")
+	if !errors.Is(err, ErrAuthRejected) {
+		t.Errorf("Auth() returned %v, want %v", err, ErrAuthRejected)
+	}
+	if IsConnError(err) {
+		t.Errorf("IsConnError(%v) = true, want false", err)
+	}
+}
+
+func TestConnError(t *testing.T) {
+	n := fakeServer(t)
+
+	_, err := n.Group("alt.binaries.test")
+	if !IsConnError(err) {
+		t.Errorf("Group() returned %v, want a *ConnError", err)
+	}
+}
+
+func TestStatAll(t *testing.T) {
+	n := fakeServer(t, "223 0 <1@foo.com>", "430 No Such Article", "223 0 <3@foo.com>")
+
+	errs, err := n.StatAll([]string{"<1@foo.com>", "<2@foo.com>", "<3@foo.com>"})
+	if err != nil {
+		t.Fatalf("StatAll() returned %v", err)
+	}
+
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("StatAll() returned %v, want no errors for existing articles", errs)
+	}
+	if !errors.Is(errs[1], ErrNoSuchArticle) {
+		t.Errorf("StatAll() returned %v for missing article, want %v", errs[1], ErrNoSuchArticle)
+	}
+}
+
+func TestBodyCompressGzip(t *testing.T) {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write([]byte("=ybegin line=128 size=1 name=a\r\n..x\r\n.\r\n"))
+	w.Close()
+
+	n := fakeServer(t, "290 feature enabled", "222 0 <1@foo.com>\r\n"+compressed.String()+".")
+	if err := n.compressGzip(); err != nil {
+		t.Fatalf("compressGzip() returned %v", err)
+	}
+
+	_, _, body, err := n.Body("<1@foo.com>")
+	if err != nil {
+		t.Fatalf("Body() returned %v", err)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("ReadAll() returned %v", err)
+	}
+
+	want := "=ybegin line=128 size=1 name=a\n.x\n"
+	if string(data) != want {
+		t.Errorf("Body() returned %q, want %q", data, want)
+	}
+
+	stats := n.Stats()
+	if stats.UncompressedBytes == 0 || stats.CompressedBytes != int64(compressed.Len()) {
+		t.Errorf("Stats() returned %+v, want %d compressed bytes", stats, compressed.Len())
+	}
+}
+
+func TestCompressDeflate(t *testing.T) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	go func() {
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		reader.ReadString('\n')
+		server.Write([]byte("101 Capability list:\r\nVERSION 2\r\nCOMPRESS DEFLATE\r\n.\r\n"))
+		reader.ReadString('\n')
+		server.Write([]byte("206 Compression active\r\n"))
+
+		compressed := bufio.NewReader(flate.NewReader(reader))
+		w, _ := flate.NewWriter(server, flate.DefaultCompression)
+		compressed.ReadString('\n')
+		w.Write([]byte("211 1 1 1 alt.binaries.test\r\n"))
+		w.Flush()
+	}()
+
+	n := &NNTP{raw: client, conn: textproto.NewConn(client)}
+	if err := n.refreshCapabilities(); err != nil {
+		t.Fatalf("refreshCapabilities() returned %v", err)
+	}
+	if err := n.Compress(); err != nil {
+		t.Fatalf("Compress() returned %v", err)
+	}
+
+	msg, err := n.Group("alt.binaries.test")
+	if err != nil {
+		t.Fatalf("Group() returned %v", err)
+	}
+	if msg != "1 1 1 alt.binaries.test" {
+		t.Errorf("Group() returned %q", msg)
+	}
+}
+
+func TestNewModeReader(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	commands := make(chan string, 4)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("200 Welcome")
+		responses := []string{
+			"101 Capability list:\r\nVERSION 2\r\nMODE-READER\r\n.",
+			"200 Reader mode",
+			"101 Capability list:\r\nVERSION 2\r\nREADER\r\nSTARTTLS\r\n.",
+		}
+		for _, response := range responses {
+			command, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			commands <- command
+			tp.PrintfLine("%s", response)
+		}
+		tp.ReadLine()
+	}()
+
+	n, err := New("tcp", listener.Addr().String(), SecurityNone, nil)
+	if err != nil {
+		t.Fatalf("New() returned %v", err)
+	}
+	defer n.Close()
+
+	close(commands)
+	var got []string
+	for command := range commands {
+		got = append(got, command)
+	}
+
+	want := []string{"CAPABILITIES", "MODE READER", "CAPABILITIES"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("New() sent %v, want %v", got, want)
+	}
+	if !n.Capabilities().Has("reader") || !n.Capabilities().StartTLS() {
+		t.Errorf("Capabilities() returned %+v, want READER and STARTTLS", n.Capabilities())
+	}
+}
+
+func Test_parseCapabilities(t *testing.T) {
+	caps := parseCapabilities([]string{"VERSION 2 3", "READER", "COMPRESS DEFLATE SHRINK"})
+
+	if caps.Version != 3 {
+		t.Errorf("Version = %d, want 3", caps.Version)
+	}
+	if caps.NeedsModeReader() {
+		t.Errorf("NeedsModeReader() = true, want false")
+	}
+	if !caps.HasArg("compress", "deflate") || caps.compression() != compressDeflate {
+		t.Errorf("compression() = %v, want compressDeflate", caps.compression())
+	}
+	if !caps.Pipelining() {
+		t.Errorf("Pipelining() = false, want true")
+	}
+
+	legacy := parseCapabilities(nil)
+	if !legacy.NeedsModeReader() || legacy.Pipelining() {
+		t.Errorf("legacy server returned NeedsModeReader() = %v, Pipelining() = %v", legacy.NeedsModeReader(), legacy.Pipelining())
+	}
+}
+
+func TestStartTLSUnsupported(t *testing.T) {
+	n := fakeServer(t)
+
+	if err := n.startTLS("localhost:119", nil); err != ErrStartTLSUnsupported {
+		t.Errorf("startTLS() returned %v, want %v", err, ErrStartTLSUnsupported)
+	}
+
+	n = fakeServer(t, "502 STARTTLS not allowed")
+	n.caps = parseCapabilities([]string{"VERSION 2", "READER", "STARTTLS"})
+
+	if err := n.startTLS("localhost:119", nil); err != ErrStartTLSUnsupported {
+		t.Errorf("startTLS() returned %v, want %v", err, ErrStartTLSUnsupported)
+	}
+}
+
+func TestPipelinedBody(t *testing.T) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	go func() {
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		// Read every command before answering any of them.
+		for i := 0; i < 3; i++ {
+			reader.ReadString('\n')
+		}
+		server.Write([]byte("222 0 <1@foo.com>\r\none\r\n.\r\n430 No Such Article\r\n222 0 <3@foo.com>\r\nthree\r\n.\r\n"))
+	}()
+
+	n := &NNTP{conn: textproto.NewConn(client), caps: parseCapabilities([]string{"VERSION 2"})}
+	for _, id := range []string{"<1@foo.com>", "<2@foo.com>", "<3@foo.com>"} {
+		if err := n.SendBody(id); err != nil {
+			t.Fatalf("SendBody(%v) returned %v", id, err)
+		}
+	}
+
+	want := []string{"one\n", "", "three\n"}
+	for i, body := range want {
+		_, _, reader, err := n.ReadBody()
+		if body == "" {
+			if !errors.Is(err, ErrNoSuchArticle) {
+				t.Errorf("ReadBody() #%d returned %v, want %v", i, err, ErrNoSuchArticle)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ReadBody() #%d returned %v", i, err)
+		}
+		data, _ := ioutil.ReadAll(reader)
+		if string(data) != body {
+			t.Errorf("ReadBody() #%d returned %q, want %q", i, data, body)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package nntp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+var (
+	ErrStartTLSUnsupported = errors.New("nntp: server does not support STARTTLS")
+	ErrFingerprintMismatch = errors.New("nntp: certificate fingerprint does not match")
+)
+
+// TLSConfig holds the settings for SecurityTLS and SecurityStartTLS
+// connections. The zero value verifies the server against the system CAs.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of CAs to trust instead of the system ones.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key.
+	CertFile string
+	KeyFile  string
+	// Fingerprint is the hex SHA-256 of the server's certificate. When set,
+	// the server must present that certificate, on top of the usual checks.
+	Fingerprint string
+	// ServerName overrides the host name the certificate is checked against.
+	ServerName string
+	// MinVersion is the lowest TLS version to accept, e.g. "1.2".
+	MinVersion string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Check reports settings that can't work, without reading any files.
+func (c *TLSConfig) Check() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("nntp: CertFile and KeyFile must be set together")
+	}
+
+	if _, ok := tlsVersions[c.MinVersion]; c.MinVersion != "" && !ok {
+		return fmt.Errorf("nntp: unknown TLS version %q", c.MinVersion)
+	}
+
+	if c.Fingerprint != "" {
+		if _, err := parseFingerprint(c.Fingerprint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseFingerprint(fingerprint string) ([]byte, error) {
+	pin, err := hex.DecodeString(strings.Replace(fingerprint, ":", "", -1))
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("nntp: fingerprint %q is not a hex SHA-256 hash", fingerprint)
+	}
+
+	return pin, nil
+}
+
+// Builds a *tls.Config for connecting to addr.
+func (c *TLSConfig) config(addr string) (*tls.Config, error) {
+	if c == nil {
+		c = &TLSConfig{}
+	}
+
+	config := &tls.Config{ServerName: c.ServerName}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("nntp: no certificates found in %q", c.CAFile)
+		}
+	} else {
+		certPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = certPool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("nntp: unknown TLS version %q", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if c.Fingerprint != "" {
+		pin, err := parseFingerprint(c.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPin(state, pin)
+		}
+	}
+
+	return config, nil
+}
+
+func verifyPin(state tls.ConnectionState, pin []byte) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("nntp: server presented no certificate")
+	}
+
+	fingerprint := sha256.Sum256(state.PeerCertificates[0].Raw)
+	if !bytes.Equal(fingerprint[:], pin) {
+		return fmt.Errorf("%w: got %x, pinned %x", ErrFingerprintMismatch, fingerprint, pin)
+	}
+
+	return nil
+}
+
+// Upgrades the connection to TLS with STARTTLS. It fails rather than
+// carrying on in plaintext if the server doesn't offer it.
+func (n *NNTP) startTLS(addr string, tlsConfig *TLSConfig) error {
+	if !n.caps.StartTLS() {
+		return ErrStartTLSUnsupported
+	}
+
+	config, err := tlsConfig.config(addr)
+	if err != nil {
+		return err
+	}
+
+	if err := n.conn.PrintfLine("STARTTLS"); err != nil {
+		return wrapError(err)
+	}
+
+	if _, _, err := n.conn.ReadCodeLine(382); err != nil {
+		err = wrapError(err)
+		if IsConnError(err) {
+			return err
+		}
+		return ErrStartTLSUnsupported
+	}
+
+	tlsConn := tls.Client(n.raw, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return &ConnError{Err: err}
+	}
+
+	n.raw = tlsConn
+	n.conn = textproto.NewConn(tlsConn)
+
+	// Capabilities from before the upgrade must be discarded (RFC 4642 2.2.2).
+	return n.refreshCapabilities()
+}
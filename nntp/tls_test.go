@@ -0,0 +1,181 @@
+package nntp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a self-signed certificate for news.example.com, the path of a
+// PEM copy of it and its SHA-256 fingerprint.
+func testCertificate(t *testing.T) (tls.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "news.example.com"},
+		DNSNames:              []string{"news.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := sha256.Sum256(der)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile, hex.EncodeToString(fingerprint[:])
+}
+
+// Starts a TLS server with a self-signed certificate for news.example.com
+// that greets each client and then hangs up. Returns its address, the path
+// of the certificate and the certificate's SHA-256 fingerprint.
+func tlsServer(t *testing.T) (string, string, string) {
+	cert, caFile, fingerprint := testCertificate(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.Write([]byte("200 Welcome\r\n"))
+				conn.Read(make([]byte, 64))
+				conn.Write([]byte("101 Capability list:\r\nVERSION 2\r\nREADER\r\n.\r\n"))
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String(), caFile, fingerprint
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	addr, caFile, fingerprint := tlsServer(t)
+
+	config := &TLSConfig{CAFile: caFile, ServerName: "news.example.com", Fingerprint: fingerprint, MinVersion: "1.2"}
+	n, err := New("tcp", addr, SecurityTLS, config)
+	if err != nil {
+		t.Fatalf("New() returned %v", err)
+	}
+	n.Close()
+
+	if _, err := New("tcp", addr, SecurityTLS, &TLSConfig{ServerName: "news.example.com"}); err == nil {
+		t.Errorf("New() with system CAs connected to a self-signed server")
+	}
+
+	config.Fingerprint = hex.EncodeToString(make([]byte, sha256.Size))
+	if _, err := New("tcp", addr, SecurityTLS, config); err == nil {
+		t.Errorf("New() connected despite a mismatched fingerprint")
+	}
+}
+
+// Starts a fake server on a pipe that upgrades with STARTTLS and then
+// advertises COMPRESS, and returns a client for it.
+func startTLSServer(t *testing.T, cert tls.Certificate) *NNTP {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	go func() {
+		defer server.Close()
+		tp := textproto.NewConn(server)
+		if line, err := tp.ReadLine(); err != nil || line != "STARTTLS" {
+			return
+		}
+		tp.PrintfLine("382 Continue with TLS negotiation")
+
+		tlsConn := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		tp = textproto.NewConn(tlsConn)
+		if line, err := tp.ReadLine(); err != nil || line != "CAPABILITIES" {
+			return
+		}
+		tp.PrintfLine("101 Capability list:\r\nVERSION 2\r\nREADER\r\nCOMPRESS DEFLATE\r\n.")
+	}()
+
+	return &NNTP{
+		raw:  client,
+		conn: textproto.NewConn(client),
+		caps: parseCapabilities([]string{"VERSION 2", "READER", "STARTTLS"}),
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	cert, caFile, fingerprint := testCertificate(t)
+	config := &TLSConfig{CAFile: caFile, Fingerprint: fingerprint}
+
+	n := startTLSServer(t, cert)
+	if err := n.startTLS("news.example.com:119", config); err != nil {
+		t.Fatalf("startTLS() returned %v", err)
+	}
+	if _, ok := n.raw.(*tls.Conn); !ok {
+		t.Errorf("startTLS() left a %T, want a *tls.Conn", n.raw)
+	}
+	// The capabilities from after the upgrade replace those from before.
+	if n.caps.StartTLS() || !n.caps.Has("COMPRESS") {
+		t.Errorf("startTLS() kept capabilities %+v", n.caps)
+	}
+
+	config.Fingerprint = hex.EncodeToString(make([]byte, sha256.Size))
+	n = startTLSServer(t, cert)
+	if err := n.startTLS("news.example.com:119", config); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("startTLS() returned %v, want %v", err, ErrFingerprintMismatch)
+	}
+}
+
+func TestTLSConfigCheck(t *testing.T) {
+	pin := strings.Repeat("ab", 32)
+	tests := []struct {
+		config TLSConfig
+		ok     bool
+	}{
+		{TLSConfig{}, true},
+		{TLSConfig{MinVersion: "1.2", Fingerprint: pin}, true},
+		{TLSConfig{CertFile: "client.pem", KeyFile: "client.key"}, true},
+		{TLSConfig{CertFile: "client.pem"}, false},
+		{TLSConfig{MinVersion: "2.0"}, false},
+		{TLSConfig{Fingerprint: "ab:cd"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.config.Check(); (err == nil) != test.ok {
+			t.Errorf("Check() for %+v returned %v", test.config, err)
+		}
+	}
+}
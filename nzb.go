@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"./kumo"
+	"github.com/sww/dumblog"
+)
+
+const nzbUsage = `usage: kumo nzb <command> [flags] file.nzb...
+
+commands:
+  filter  drop the files matching the configured filters
+  merge   merge NZBs for the same release into one
+  split   write one NZB per set of files, named after the set
+  subset  keep only the files matching -match, or missing from the servers with -missing`
+
+// Runs the kumo nzb subcommands, which edit NZBs without downloading them.
+func runNZB(config *kumo.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(nzbUsage)
+	}
+
+	flags := flag.NewFlagSet("nzb "+args[0], flag.ExitOnError)
+	output := flags.String("o", "", "write the NZB to this file instead of stdout")
+	dir := flags.String("dir", ".", "directory split writes its NZBs to")
+	match := flags.String("match", "", "regexp the subjects of the files subset keeps must match")
+	missing := flags.Bool("missing", false, "subset keeps the files with articles missing from the servers")
+	flags.Parse(args[1:])
+
+	nzbs, err := readNZBs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "filter":
+		filter, err := kumo.NewConfigFilter(config)
+		if err != nil {
+			return err
+		}
+		filter.Logger = dumblog.New(config.Debug)
+		return writeNZB(*output, filter.FilterNzb(kumo.Merge(nzbs...)))
+	case "merge":
+		return writeNZB(*output, kumo.Merge(nzbs...))
+	case "split":
+		names, sets := kumo.Merge(nzbs...).Sets()
+		for _, name := range names {
+			filename := name
+			if filename == "" {
+				filename = "unnamed"
+			}
+			if err := writeNZB(filepath.Join(*dir, filepath.Base(filename)+".nzb"), sets[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "subset":
+		nzb := kumo.Merge(nzbs...)
+		keep, err := subsetFilter(config, nzb, *match, *missing)
+		if err != nil {
+			return err
+		}
+		return writeNZB(*output, nzb.Subset(keep))
+	}
+
+	return fmt.Errorf("unknown command %q\n%s", args[0], nzbUsage)
+}
+
+func readNZBs(filenames []string) ([]*kumo.NZB, error) {
+	if len(filenames) == 0 {
+		return nil, errors.New("no NZB files given")
+	}
+
+	var nzbs []*kumo.NZB
+	for _, filename := range filenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		nzb, err := kumo.Parse(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+
+		for _, problem := range nzb.Normalize() {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, problem)
+		}
+		if len(nzb.Files) == 0 {
+			return nil, fmt.Errorf("%s: %w", filename, kumo.ErrEmptyNZB)
+		}
+		nzbs = append(nzbs, nzb)
+	}
+
+	return nzbs, nil
+}
+
+// Writes nzb to filename, or to stdout if filename is empty.
+func writeNZB(filename string, nzb *kumo.NZB) error {
+	var w io.Writer = os.Stdout
+	if filename != "" {
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return nzb.Write(w)
+}
+
+func subsetFilter(config *kumo.Config, nzb *kumo.NZB, match string, missing bool) (func(kumo.File) bool, error) {
+	if missing {
+		if err := config.Validate(kumo.ServerSettings); err != nil {
+			return nil, err
+		}
+		result, err := kumo.New(config).CheckNZB(nzb)
+		if err != nil {
+			return nil, err
+		}
+
+		incomplete := make(map[string]bool)
+		for _, file := range result.Files {
+			if file.MissingSegments > 0 {
+				incomplete[file.Subject] = true
+			}
+		}
+		return func(file kumo.File) bool { return incomplete[file.Subject] }, nil
+	}
+
+	if match == "" {
+		return nil, errors.New("subset needs -match or -missing")
+	}
+	re, err := regexp.Compile(match)
+	if err != nil {
+		return nil, err
+	}
+	return func(file kumo.File) bool { return re.MatchString(file.Subject) }, nil
+}
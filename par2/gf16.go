@@ -0,0 +1,122 @@
+package par2
+
+// Arithmetic in GF(2^16) with the generator polynomial PAR2 uses,
+// x^16 + x^12 + x^3 + x + 1.
+
+const (
+	gfBits      = 16
+	gfSize      = 1 << gfBits
+	gfLimit     = gfSize - 1
+	gfGenerator = 0x1100B
+)
+
+var (
+	gfLog [gfSize]uint16
+	gfExp [gfSize]uint16
+)
+
+func init() {
+	b := uint32(1)
+	for l := 0; l < gfLimit; l++ {
+		gfLog[b] = uint16(l)
+		gfExp[l] = uint16(b)
+		b <<= 1
+		if b&gfSize != 0 {
+			b ^= gfGenerator
+		}
+	}
+	gfLog[0] = gfLimit
+	gfExp[gfLimit] = 0
+}
+
+func gfMul(a, b uint16) uint16 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	sum := uint32(gfLog[a]) + uint32(gfLog[b])
+	if sum >= gfLimit {
+		sum -= gfLimit
+	}
+
+	return gfExp[sum]
+}
+
+func gfDiv(a, b uint16) uint16 {
+	if a == 0 {
+		return 0
+	}
+	if b == 0 {
+		panic("par2: division by zero in GF(2^16)")
+	}
+
+	diff := int(gfLog[a]) - int(gfLog[b])
+	if diff < 0 {
+		diff += gfLimit
+	}
+
+	return gfExp[diff]
+}
+
+func gfPow(a uint16, n uint32) uint16 {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+
+	return gfExp[(uint64(gfLog[a])*uint64(n))%gfLimit]
+}
+
+// Most input slices a recovery set can have: there are only this many
+// exponents coprime to 65535.
+const maxInputSlices = 32768
+
+// Returns the constant for each of count input slices, which must be at
+// most maxInputSlices. The constant for a
+// slice is 2^n, where n runs through the values coprime to 65535.
+func inputConstants(count int) []uint16 {
+	constants := make([]uint16, count)
+	n := 0
+	for i := range constants {
+		for gcd(gfLimit, n) != 1 {
+			n++
+		}
+		constants[i] = gfExp[n]
+		n++
+	}
+
+	return constants
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
+
+// mulTable holds the products of a constant with every low and high byte,
+// since multiplying a 16-bit word is linear in its bytes.
+type mulTable [2][256]uint16
+
+func newMulTable(c uint16) *mulTable {
+	t := new(mulTable)
+	for i := 0; i < 256; i++ {
+		t[0][i] = gfMul(c, uint16(i))
+		t[1][i] = gfMul(c, uint16(i)<<8)
+	}
+
+	return t
+}
+
+// Adds c times src into dst, treating both as little endian 16-bit words.
+func (t *mulTable) mulAdd(dst, src []byte) {
+	for i := 0; i+1 < len(src); i += 2 {
+		product := t[0][src[i]] ^ t[1][src[i+1]]
+		dst[i] ^= byte(product)
+		dst[i+1] ^= byte(product >> 8)
+	}
+}
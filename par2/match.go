@@ -0,0 +1,197 @@
+package par2
+
+import (
+	"crypto/md5"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Size of the start of a file hashed into File.MD5_16.
+const md5_16Size = 16384
+
+// Match finds the files in dir that belong to the recovery set under another
+// name, such as obfuscated posts, and returns their real names keyed by the
+// names they have. Files are matched by the MD5 of their first 16KB and
+// their length, and by their full MD5 if that isn't enough to tell them
+// apart. Damaged files, which match neither, are matched to the file most of
+// whose slice checksums they share.
+func (s *Set) Match(dir string) (map[string]string, error) {
+	files, err := s.RecoveryFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		md5_16 [16]byte
+		length int64
+	}
+	candidates := make(map[key][]*File)
+	for _, file := range files {
+		if file.Name == "" {
+			continue
+		}
+		k := key{file.MD5_16, file.Length}
+		candidates[k] = append(candidates[k], file)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	renames := make(map[string]string)
+	claimed := make(map[string]bool)
+	var unmatched []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.EqualFold(filepath.Ext(name), ".par2") {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		md5_16, err := hashFile(path, md5_16Size)
+		if err != nil {
+			return nil, err
+		}
+
+		matches := candidates[key{md5_16, entry.Size()}]
+		if len(matches) > 1 {
+			whole, err := hashFile(path, -1)
+			if err != nil {
+				return nil, err
+			}
+
+			var exact []*File
+			for _, file := range matches {
+				if file.MD5 == whole {
+					exact = append(exact, file)
+				}
+			}
+			matches = exact
+		}
+
+		if len(matches) != 1 || claimed[matches[0].Name] {
+			unmatched = append(unmatched, name)
+			continue
+		}
+
+		claimed[matches[0].Name] = true
+		if matches[0].Name != name {
+			renames[name] = matches[0].Name
+		}
+	}
+
+	index := sliceIndex(files)
+	for _, name := range unmatched {
+		file, err := s.matchSlices(filepath.Join(dir, name), index, claimed)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			continue
+		}
+
+		claimed[file.Name] = true
+		if file.Name != name {
+			renames[name] = file.Name
+		}
+	}
+
+	return renames, nil
+}
+
+// Returns the files that have a slice with each checksum.
+func sliceIndex(files []*File) map[SliceChecksum][]*File {
+	index := make(map[SliceChecksum][]*File)
+	for _, file := range files {
+		if file.Name == "" {
+			continue
+		}
+		for _, checksum := range file.Slices {
+			if owners := index[checksum]; len(owners) == 0 || owners[len(owners)-1] != file {
+				index[checksum] = append(owners, file)
+			}
+		}
+	}
+
+	return index
+}
+
+// Returns the unclaimed file that more than half of the slices of the file
+// at path match, or nil if there isn't one. The file is read a slice at a
+// time and each slice looked up in index.
+func (s *Set) matchSlices(path string, index map[SliceChecksum][]*File, claimed map[string]bool) (*File, error) {
+	if s.SliceSize <= 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	counts := make(map[*File]int)
+	slice := make([]byte, s.SliceSize)
+	for {
+		n, err := io.ReadFull(f, slice)
+		if n > 0 {
+			// The last slice is padded with zeros.
+			for i := n; i < len(slice); i++ {
+				slice[i] = 0
+			}
+
+			checksum := SliceChecksum{MD5: md5.Sum(slice), CRC32: crc32.ChecksumIEEE(slice)}
+			for _, file := range index[checksum] {
+				counts[file]++
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var best *File
+	bestCount := 0
+	for file, count := range counts {
+		if claimed[file.Name] || 2*count <= len(file.Slices) {
+			continue
+		}
+		if count > bestCount || (count == bestCount && file.Name < best.Name) {
+			best, bestCount = file, count
+		}
+	}
+
+	return best, nil
+}
+
+// Returns the MD5 of the first n bytes of the file at path, or of all of it
+// if n is negative.
+func hashFile(path string, n int64) ([16]byte, error) {
+	var sum [16]byte
+
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if n >= 0 {
+		r = io.LimitReader(f, n)
+	}
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return sum, err
+	}
+	copy(sum[:], hash.Sum(nil))
+
+	return sum, nil
+}
@@ -0,0 +1,94 @@
+package par2
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	files := map[string][]byte{
+		"movie.part1.rar": bytes.Repeat([]byte("rar volume 1 "), 3),
+		"movie.part2.rar": bytes.Repeat([]byte("rar volume 2 "), 3),
+		// These only differ after the first 16KB.
+		"same1.bin": append(bytes.Repeat([]byte("x"), md5_16Size), '1'),
+		"same2.bin": append(bytes.Repeat([]byte("x"), md5_16Size), '2'),
+		"named.nfo": []byte("already named"),
+	}
+	names := []string{"movie.part1.rar", "movie.part2.rar", "same1.bin", "same2.bin", "named.nfo"}
+	index, _ := buildSet(names, files, 1024)
+
+	set := NewSet()
+	if err := set.Read(index); err != nil {
+		t.Fatalf("Read() returned %v", err)
+	}
+
+	dir := t.TempDir()
+	obfuscated := map[string]string{
+		"a8f3e2":    "movie.part1.rar",
+		"77c0d1":    "movie.part2.rar",
+		"same2.bin": "same1.bin",
+		"same1.bin": "same2.bin",
+		"named.nfo": "named.nfo",
+	}
+	for name, real := range obfuscated {
+		ioutil.WriteFile(filepath.Join(dir, name), files[real], 0644)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "unrelated.txt"), []byte("not in the set"), 0644)
+
+	renames, err := set.Match(dir)
+	if err != nil {
+		t.Fatalf("Match() returned %v", err)
+	}
+
+	for name, real := range obfuscated {
+		if name == real {
+			if _, ok := renames[name]; ok {
+				t.Errorf("Match() renamed %s, which already has its real name", name)
+			}
+			continue
+		}
+		if renames[name] != real {
+			t.Errorf("Match() renamed %s to %q, want %q", name, renames[name], real)
+		}
+	}
+	if len(renames) != 4 {
+		t.Errorf("Match() returned %d renames, want 4: %v", len(renames), renames)
+	}
+}
+
+func TestMatchDamaged(t *testing.T) {
+	files := map[string][]byte{
+		"video.mkv":  bytes.Repeat([]byte("0123456789abcdef"), 192),
+		"audio.flac": bytes.Repeat([]byte("fedcba9876543210"), 192),
+	}
+	names := []string{"video.mkv", "audio.flac"}
+	index, _ := buildSet(names, files, 1024)
+
+	set := NewSet()
+	if err := set.Read(index); err != nil {
+		t.Fatalf("Read() returned %v", err)
+	}
+
+	dir := t.TempDir()
+	// One of three slices damaged, so it still matches.
+	video := append([]byte(nil), files["video.mkv"]...)
+	video[100] = 'X'
+	ioutil.WriteFile(filepath.Join(dir, "e41b07"), video, 0644)
+	// Two of three slices damaged, too few to tell.
+	audio := append([]byte(nil), files["audio.flac"]...)
+	audio[100] = 'X'
+	audio[2000] = 'X'
+	ioutil.WriteFile(filepath.Join(dir, "9c2d55"), audio, 0644)
+
+	renames, err := set.Match(dir)
+	if err != nil {
+		t.Fatalf("Match() returned %v", err)
+	}
+
+	want := map[string]string{"e41b07": "video.mkv"}
+	if len(renames) != len(want) || renames["e41b07"] != "video.mkv" {
+		t.Errorf("Match() returned %v, want %v", renames, want)
+	}
+}
@@ -0,0 +1,374 @@
+// Package par2 reads PAR2 recovery sets and verifies and repairs files with
+// them.
+package par2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	headerSize = 64
+	// Largest non-recovery packet that will be read into memory.
+	maxPacketSize = 1 << 26
+)
+
+var (
+	magic = []byte("PAR2\x00PKT")
+
+	typeMain     = newPacketType("PAR 2.0\x00Main")
+	typeFileDesc = newPacketType("PAR 2.0\x00FileDesc")
+	typeIFSC     = newPacketType("PAR 2.0\x00IFSC")
+	typeRecovery = newPacketType("PAR 2.0\x00RecvSlic")
+
+	ErrNoMainPacket = errors.New("par2: no main packet found")
+)
+
+type ID [16]byte
+
+type packetType [16]byte
+
+// Packet types are padded with zeros to 16 bytes.
+func newPacketType(name string) packetType {
+	var t packetType
+	copy(t[:], name)
+	return t
+}
+
+// SliceChecksum is the checksum of one slice of a file, from an IFSC packet.
+type SliceChecksum struct {
+	MD5   [16]byte
+	CRC32 uint32
+}
+
+// File is a file protected by the recovery set.
+type File struct {
+	ID     ID
+	MD5    [16]byte
+	MD5_16 [16]byte // MD5 of the first 16KB
+	Length int64
+	Name   string
+	Slices []SliceChecksum
+}
+
+// SliceCount returns the number of slices the file is split into.
+func (f *File) SliceCount(sliceSize int64) int {
+	return int((f.Length + sliceSize - 1) / sliceSize)
+}
+
+// Set is a recovery set built from the packets of one or more PAR2 files.
+type Set struct {
+	ID        ID
+	SliceSize int64
+	// FileIDs lists the files in the recovery set, in the order the main
+	// packet gives them, which is the order used for repair.
+	FileIDs []ID
+	Files   map[ID]*File
+	// RecoveryBlocks is the number of distinct recovery slices seen.
+	RecoveryBlocks int
+	exponents      map[uint32]bool
+	recovery       []recoverySlice
+	hasMain        bool
+}
+
+// Where the data of a recovery slice can be read from.
+type recoverySlice struct {
+	exponent uint32
+	path     string
+	offset   int64
+}
+
+func NewSet() *Set {
+	return &Set{
+		Files:     make(map[ID]*File),
+		exponents: make(map[uint32]bool),
+	}
+}
+
+// ReadFile adds the packets in the PAR2 file at path to the set.
+func (s *Set) ReadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return s.read(file, path)
+}
+
+// Read adds the packets from r to the set. Damaged packets are skipped.
+// Recovery slices read this way are counted but can't be used for repair,
+// which needs ReadFile.
+func (s *Set) Read(r io.Reader) error {
+	return s.read(r, "")
+}
+
+func (s *Set) read(r io.Reader, path string) error {
+	counter := &countingReader{r: r}
+	reader := bufio.NewReader(counter)
+	position := func() int64 {
+		return counter.n - int64(reader.Buffered())
+	}
+
+	for {
+		header, err := nextHeader(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		location := recoverySlice{path: path, offset: position() + 4}
+		if err := s.readPacket(reader, header, location); err != nil && err != errBadPacket {
+			return err
+		}
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// RecoveryFiles returns the files in the recovery set in main packet order.
+func (s *Set) RecoveryFiles() ([]*File, error) {
+	if !s.hasMain {
+		return nil, ErrNoMainPacket
+	}
+
+	var files []*File
+	for _, id := range s.FileIDs {
+		files = append(files, s.file(id))
+	}
+
+	return files, nil
+}
+
+type header struct {
+	length  int64
+	hash    [16]byte
+	setID   ID
+	kind    packetType
+	rawTail []byte // the set ID and type, which are covered by the hash
+}
+
+var (
+	errBadPacket     = errors.New("par2: bad packet")
+	errTooManySlices = fmt.Errorf("par2: more than %d input slices", maxInputSlices)
+)
+
+// Scans forward to the next packet header.
+func nextHeader(r *bufio.Reader) (*header, error) {
+	for {
+		peek, err := r.Peek(headerSize)
+		if len(peek) < len(magic) {
+			return nil, io.EOF
+		}
+		if !bytes.Equal(peek[:len(magic)], magic) {
+			r.Discard(1)
+			continue
+		}
+		if err != nil {
+			return nil, io.EOF
+		}
+
+		h := &header{length: int64(binary.LittleEndian.Uint64(peek[8:16]))}
+		copy(h.hash[:], peek[16:32])
+		copy(h.setID[:], peek[32:48])
+		copy(h.kind[:], peek[48:64])
+		h.rawTail = append([]byte(nil), peek[32:64]...)
+
+		if h.length < headerSize || h.length%4 != 0 {
+			r.Discard(1)
+			continue
+		}
+
+		r.Discard(headerSize)
+		return h, nil
+	}
+}
+
+// Reads the body of the packet with header h and adds it to the set. If it
+// is a recovery slice, location says where its data starts.
+func (s *Set) readPacket(r *bufio.Reader, h *header, location recoverySlice) error {
+	size := h.length - headerSize
+	hash := md5.New()
+	hash.Write(h.rawTail)
+
+	if h.kind == typeRecovery {
+		// Recovery data can be large, so hash it without keeping it.
+		var exponent [4]byte
+		if _, err := io.ReadFull(r, exponent[:]); err != nil {
+			return err
+		}
+		hash.Write(exponent[:])
+		if _, err := io.CopyN(hash, r, size-4); err != nil {
+			return err
+		}
+		if !bytes.Equal(hash.Sum(nil), h.hash[:]) {
+			return errBadPacket
+		}
+
+		if s.hasMain && h.setID != s.ID {
+			return errBadPacket
+		}
+
+		location.exponent = binary.LittleEndian.Uint32(exponent[:])
+		s.addRecovery(location)
+		return nil
+	}
+
+	if size > maxPacketSize {
+		if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+			return err
+		}
+		return errBadPacket
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	hash.Write(body)
+	if !bytes.Equal(hash.Sum(nil), h.hash[:]) {
+		return errBadPacket
+	}
+
+	if s.hasMain && h.setID != s.ID {
+		return errBadPacket
+	}
+
+	switch h.kind {
+	case typeMain:
+		return s.parseMain(h.setID, body)
+	case typeFileDesc:
+		return s.parseFileDesc(body)
+	case typeIFSC:
+		return s.parseIFSC(body)
+	}
+
+	return nil
+}
+
+func (s *Set) addRecovery(location recoverySlice) {
+	if s.exponents[location.exponent] {
+		return
+	}
+
+	s.exponents[location.exponent] = true
+	s.RecoveryBlocks++
+	if location.path != "" {
+		s.recovery = append(s.recovery, location)
+	}
+}
+
+func (s *Set) parseMain(setID ID, body []byte) error {
+	if len(body) < 12 {
+		return errBadPacket
+	}
+	if s.hasMain {
+		return nil
+	}
+
+	s.ID = setID
+	s.SliceSize = int64(binary.LittleEndian.Uint64(body[0:8]))
+	count := int(binary.LittleEndian.Uint32(body[8:12]))
+	if s.SliceSize == 0 || s.SliceSize%4 != 0 || len(body) < 12+16*count {
+		return errBadPacket
+	}
+	if count > maxInputSlices {
+		return errTooManySlices
+	}
+
+	for i := 0; i < count; i++ {
+		var id ID
+		copy(id[:], body[12+16*i:])
+		s.FileIDs = append(s.FileIDs, id)
+	}
+	s.hasMain = true
+
+	return nil
+}
+
+func (s *Set) file(id ID) *File {
+	file, ok := s.Files[id]
+	if !ok {
+		file = &File{ID: id}
+		s.Files[id] = file
+	}
+
+	return file
+}
+
+func (s *Set) parseFileDesc(body []byte) error {
+	if len(body) < 56 {
+		return errBadPacket
+	}
+
+	var id ID
+	copy(id[:], body[0:16])
+	file := s.file(id)
+	copy(file.MD5[:], body[16:32])
+	copy(file.MD5_16[:], body[32:48])
+	file.Length = int64(binary.LittleEndian.Uint64(body[48:56]))
+	file.Name = baseName(strings.TrimRight(string(body[56:]), "\x00"))
+
+	return nil
+}
+
+// Files are kept in one directory, so any path in a name is dropped, which
+// also keeps names from pointing outside of it.
+func baseName(name string) string {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+
+	return name
+}
+
+func (s *Set) parseIFSC(body []byte) error {
+	if len(body) < 16 || (len(body)-16)%20 != 0 {
+		return errBadPacket
+	}
+
+	var id ID
+	copy(id[:], body[0:16])
+	file := s.file(id)
+	if len(file.Slices) > 0 {
+		return nil
+	}
+
+	total := (len(body) - 16) / 20
+	for _, other := range s.Files {
+		total += len(other.Slices)
+	}
+	if total > maxInputSlices {
+		return errTooManySlices
+	}
+
+	for i := 16; i < len(body); i += 20 {
+		var slice SliceChecksum
+		copy(slice.MD5[:], body[i:i+16])
+		slice.CRC32 = binary.LittleEndian.Uint32(body[i+16 : i+20])
+		file.Slices = append(file.Slices, slice)
+	}
+
+	return nil
+}
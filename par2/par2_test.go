@@ -0,0 +1,127 @@
+package par2
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writePacket(w *bytes.Buffer, setID ID, kind packetType, body []byte) {
+	hash := md5.New()
+	hash.Write(setID[:])
+	hash.Write(kind[:])
+	hash.Write(body)
+
+	w.Write(magic)
+	binary.Write(w, binary.LittleEndian, uint64(headerSize+len(body)))
+	w.Write(hash.Sum(nil))
+	w.Write(setID[:])
+	w.Write(kind[:])
+	w.Write(body)
+}
+
+// Builds the index packets of a recovery set for files, keyed by name,
+// with the given slice size.
+func buildSet(names []string, files map[string][]byte, sliceSize int) (*bytes.Buffer, []ID) {
+	var ids []ID
+	var descs, ifscs [][]byte
+	for _, name := range names {
+		data := files[name]
+		first := data
+		if len(first) > 16384 {
+			first = first[:16384]
+		}
+		md5_16 := md5.Sum(first)
+		length := make([]byte, 8)
+		binary.LittleEndian.PutUint64(length, uint64(len(data)))
+
+		hash := md5.New()
+		hash.Write(md5_16[:])
+		hash.Write(length)
+		hash.Write([]byte(name))
+		var id ID
+		copy(id[:], hash.Sum(nil))
+		ids = append(ids, id)
+
+		whole := md5.Sum(data)
+		desc := append(append(append(append(id[:], whole[:]...), md5_16[:]...), length...), []byte(name)...)
+		for len(desc)%4 != 0 {
+			desc = append(desc, 0)
+		}
+		descs = append(descs, desc)
+
+		ifsc := append([]byte(nil), id[:]...)
+		for i := 0; i < len(data); i += sliceSize {
+			slice := make([]byte, sliceSize)
+			copy(slice, data[i:])
+			sum := md5.Sum(slice)
+			ifsc = append(ifsc, sum[:]...)
+			ifsc = binary.LittleEndian.AppendUint32(ifsc, crc32.ChecksumIEEE(slice))
+		}
+		ifscs = append(ifscs, ifsc)
+	}
+
+	main := binary.LittleEndian.AppendUint64(nil, uint64(sliceSize))
+	main = binary.LittleEndian.AppendUint32(main, uint32(len(ids)))
+	for _, id := range ids {
+		main = append(main, id[:]...)
+	}
+	setID := ID(md5.Sum(main))
+
+	var out bytes.Buffer
+	out.WriteString("junk before the first packet")
+	writePacket(&out, setID, typeMain, main)
+	for i := range ids {
+		writePacket(&out, setID, typeFileDesc, descs[i])
+		writePacket(&out, setID, typeIFSC, ifscs[i])
+	}
+
+	return &out, ids
+}
+
+func TestVerify(t *testing.T) {
+	files := map[string][]byte{
+		"a.bin": bytes.Repeat([]byte("abcdefgh"), 5),
+		"b.bin": []byte("0123456789"),
+		"c.bin": []byte("missing"),
+	}
+	names := []string{"a.bin", "b.bin", "c.bin"}
+	index, _ := buildSet(names, files, 8)
+
+	set := NewSet()
+	if err := set.Read(index); err != nil {
+		t.Fatalf("Read() returned %v", err)
+	}
+	if set.SliceSize != 8 || len(set.FileIDs) != 3 {
+		t.Fatalf("Read() got slice size %d and %d files", set.SliceSize, len(set.FileIDs))
+	}
+
+	dir := t.TempDir()
+	damaged := append([]byte(nil), files["a.bin"]...)
+	damaged[17] = 'X'
+	ioutil.WriteFile(filepath.Join(dir, "a.bin"), damaged, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.bin"), files["b.bin"], 0644)
+
+	result, err := set.Verify(dir)
+	if err != nil {
+		t.Fatalf("Verify() returned %v", err)
+	}
+
+	a, b, c := result.Files[0], result.Files[1], result.Files[2]
+	if a.Name != "a.bin" || a.Slices != 5 || len(a.Damaged) != 1 || a.Damaged[0] != 2 {
+		t.Errorf("Returned %+v for a.bin, want slice 2 damaged", a)
+	}
+	if !b.OK() || b.Slices != 2 {
+		t.Errorf("Returned %+v for b.bin, want OK", b)
+	}
+	if !c.Missing || len(c.Damaged) != 1 {
+		t.Errorf("Returned %+v for c.bin, want missing", c)
+	}
+	if result.DamagedSlices() != 2 {
+		t.Errorf("DamagedSlices() = %d, want 2", result.DamagedSlices())
+	}
+}
@@ -0,0 +1,245 @@
+package par2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var errSingular = errors.New("par2: recovery matrix is singular")
+
+// RepairResult is the outcome of repairing a recovery set.
+type RepairResult struct {
+	// Repaired and Unrepairable count damaged slices.
+	Repaired     int
+	Unrepairable int
+	// Needed is how many more recovery slices repair would take, if there
+	// weren't enough.
+	Needed int
+}
+
+// Repair rebuilds the damaged slices in result, as returned by Verify, from
+// the set's recovery slices and rewrites the damaged files in dir.
+func (s *Set) Repair(dir string, result *Result) (*RepairResult, error) {
+	damaged := result.DamagedSlices()
+	if damaged == 0 {
+		return &RepairResult{}, nil
+	}
+	if len(s.recovery) < damaged {
+		return &RepairResult{Unrepairable: damaged, Needed: damaged - len(s.recovery)}, nil
+	}
+
+	files, err := s.RecoveryFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) != len(result.Files) {
+		return nil, fmt.Errorf("par2: result has %d files, recovery set has %d", len(result.Files), len(files))
+	}
+
+	total := int64(0)
+	for _, file := range files {
+		total += file.Length / s.SliceSize
+		if file.Length%s.SliceSize != 0 {
+			total++
+		}
+		if total > maxInputSlices {
+			return nil, errTooManySlices
+		}
+	}
+
+	// Number every slice in the recovery set, in main packet order.
+	var inputs []sliceRef
+	var missing []int
+	for i, file := range files {
+		if file.Name == "" {
+			return nil, fmt.Errorf("par2: no description for file %x", file.ID)
+		}
+
+		bad := make(map[int]bool)
+		for _, index := range result.Files[i].Damaged {
+			bad[index] = true
+		}
+
+		for index := 0; index < file.SliceCount(s.SliceSize); index++ {
+			if bad[index] {
+				missing = append(missing, len(inputs))
+			}
+			inputs = append(inputs, sliceRef{file: file, index: index})
+		}
+	}
+
+	constants := inputConstants(len(inputs))
+	recovery := s.recovery[:len(missing)]
+
+	matrix := make([][]uint16, len(missing))
+	for j, r := range recovery {
+		matrix[j] = make([]uint16, len(missing))
+		for k, input := range missing {
+			matrix[j][k] = gfPow(constants[input], r.exponent)
+		}
+	}
+	inverse, err := invert(matrix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start from the recovery data and subtract every slice that is still
+	// intact, leaving only the contribution of the missing slices.
+	sums := make([][]byte, len(recovery))
+	for j, r := range recovery {
+		sums[j] = make([]byte, s.SliceSize)
+		if err := readAt(r.path, r.offset, sums[j]); err != nil {
+			return nil, err
+		}
+	}
+
+	isMissing := make(map[int]bool)
+	for _, input := range missing {
+		isMissing[input] = true
+	}
+
+	data := make([]byte, s.SliceSize)
+	for g, input := range inputs {
+		if isMissing[g] {
+			continue
+		}
+
+		if err := s.readSlice(dir, input, data); err != nil {
+			return nil, err
+		}
+		for j, r := range recovery {
+			newMulTable(gfPow(constants[g], r.exponent)).mulAdd(sums[j], data)
+		}
+	}
+
+	for k, input := range missing {
+		for i := range data {
+			data[i] = 0
+		}
+		for j := range recovery {
+			newMulTable(inverse[k][j]).mulAdd(data, sums[j])
+		}
+
+		if err := s.writeSlice(dir, inputs[input], data); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range files {
+		if err := os.Truncate(filepath.Join(dir, file.Name), file.Length); err != nil {
+			return nil, err
+		}
+	}
+
+	after, err := s.Verify(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := after.DamagedSlices()
+	return &RepairResult{Repaired: damaged - remaining, Unrepairable: remaining}, nil
+}
+
+type sliceRef struct {
+	file  *File
+	index int
+}
+
+func readAt(path string, offset int64, data []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.ReadAt(data, offset)
+	return err
+}
+
+// Reads a slice of an input file, padding it with zeros past the end.
+func (s *Set) readSlice(dir string, input sliceRef, data []byte) error {
+	for i := range data {
+		data[i] = 0
+	}
+
+	f, err := os.Open(filepath.Join(dir, input.file.Name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset := int64(input.index) * s.SliceSize
+	length := input.file.Length - offset
+	if length > s.SliceSize {
+		length = s.SliceSize
+	}
+
+	_, err = f.ReadAt(data[:length], offset)
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+func (s *Set) writeSlice(dir string, input sliceRef, data []byte) error {
+	f, err := os.OpenFile(filepath.Join(dir, input.file.Name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset := int64(input.index) * s.SliceSize
+	length := input.file.Length - offset
+	if length > s.SliceSize {
+		length = s.SliceSize
+	}
+
+	_, err = f.WriteAt(data[:length], offset)
+	return err
+}
+
+// Inverts a square matrix over GF(2^16) by Gauss-Jordan elimination.
+func invert(matrix [][]uint16) ([][]uint16, error) {
+	n := len(matrix)
+	a := make([][]uint16, n)
+	inverse := make([][]uint16, n)
+	for i := range matrix {
+		a[i] = append([]uint16(nil), matrix[i]...)
+		inverse[i] = make([]uint16, n)
+		inverse[i][i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && a[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errSingular
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inverse[col], inverse[pivot] = inverse[pivot], inverse[col]
+
+		scale := a[col][col]
+		for j := 0; j < n; j++ {
+			a[col][j] = gfDiv(a[col][j], scale)
+			inverse[col][j] = gfDiv(inverse[col][j], scale)
+		}
+
+		for row := 0; row < n; row++ {
+			if row == col || a[row][col] == 0 {
+				continue
+			}
+			factor := a[row][col]
+			for j := 0; j < n; j++ {
+				a[row][j] ^= gfMul(factor, a[col][j])
+				inverse[row][j] ^= gfMul(factor, inverse[col][j])
+			}
+		}
+	}
+
+	return inverse, nil
+}
@@ -0,0 +1,203 @@
+package par2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Appends recovery packets for the given exponents, computed from files.
+func writeRecovery(w *bytes.Buffer, setID ID, names []string, files map[string][]byte, sliceSize int, exponents []uint32) {
+	var slices [][]byte
+	for _, name := range names {
+		data := files[name]
+		for i := 0; i < len(data); i += sliceSize {
+			slice := make([]byte, sliceSize)
+			copy(slice, data[i:])
+			slices = append(slices, slice)
+		}
+	}
+
+	constants := inputConstants(len(slices))
+	for _, exponent := range exponents {
+		recovery := make([]byte, sliceSize)
+		for i, slice := range slices {
+			newMulTable(gfPow(constants[i], exponent)).mulAdd(recovery, slice)
+		}
+
+		body := binary.LittleEndian.AppendUint32(nil, exponent)
+		writePacket(w, setID, typeRecovery, append(body, recovery...))
+	}
+}
+
+func TestRepair(t *testing.T) {
+	files := map[string][]byte{
+		"a.bin": bytes.Repeat([]byte("abcdefgh"), 5),
+		"b.bin": []byte("0123456789"),
+		"c.bin": []byte("missing"),
+	}
+	names := []string{"a.bin", "b.bin", "c.bin"}
+	index, ids := buildSet(names, files, 8)
+
+	set := NewSet()
+	if err := set.Read(bytes.NewReader(index.Bytes())); err != nil {
+		t.Fatalf("Read() returned %v", err)
+	}
+
+	writeRecovery(index, set.ID, names, files, 8, []uint32{0, 1, 2})
+
+	dir := t.TempDir()
+	par2File := filepath.Join(dir, "set.vol0+3.par2")
+	ioutil.WriteFile(par2File, index.Bytes(), 0644)
+
+	damaged := append([]byte(nil), files["a.bin"]...)
+	damaged[17] = 'X'
+	ioutil.WriteFile(filepath.Join(dir, "a.bin"), damaged, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.bin"), append(files["b.bin"], "extra"...), 0644)
+
+	set = NewSet()
+	if err := set.ReadFile(par2File); err != nil {
+		t.Fatalf("ReadFile() returned %v", err)
+	}
+	if set.RecoveryBlocks != 3 || len(set.FileIDs) != len(ids) {
+		t.Fatalf("ReadFile() got %d recovery blocks and %d files", set.RecoveryBlocks, len(set.FileIDs))
+	}
+
+	result, err := set.Verify(dir)
+	if err != nil {
+		t.Fatalf("Verify() returned %v", err)
+	}
+
+	repair, err := set.Repair(dir, result)
+	if err != nil {
+		t.Fatalf("Repair() returned %v", err)
+	}
+	if repair.Repaired != result.DamagedSlices() || repair.Unrepairable != 0 {
+		t.Errorf("Repair() returned %+v, want %d repaired", repair, result.DamagedSlices())
+	}
+
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || !bytes.Equal(data, files[name]) {
+			t.Errorf("%s = %q, %v after repair, want %q", name, data, err, files[name])
+		}
+	}
+}
+
+func TestRepairNotEnoughRecovery(t *testing.T) {
+	files := map[string][]byte{"a.bin": bytes.Repeat([]byte("abcdefgh"), 5)}
+	names := []string{"a.bin"}
+	index, _ := buildSet(names, files, 8)
+
+	set := NewSet()
+	set.Read(bytes.NewReader(index.Bytes()))
+	writeRecovery(index, set.ID, names, files, 8, []uint32{0})
+
+	dir := t.TempDir()
+	par2File := filepath.Join(dir, "set.vol0+1.par2")
+	ioutil.WriteFile(par2File, index.Bytes(), 0644)
+
+	set = NewSet()
+	set.ReadFile(par2File)
+
+	result, _ := set.Verify(dir)
+	repair, err := set.Repair(dir, result)
+	if err != nil {
+		t.Fatalf("Repair() returned %v", err)
+	}
+	if repair.Repaired != 0 || repair.Unrepairable != 5 || repair.Needed != 4 {
+		t.Errorf("Repair() returned %+v, want 5 unrepairable and 4 needed", repair)
+	}
+}
+
+// Repairs a file with a recovery set generated independently of this
+// package, see testdata/generate.py.
+func TestRepairFixture(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/fixture.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"fixture.par2", "fixture.vol0+2.par2"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+
+	// Damage the first and last slices.
+	damaged := append([]byte(nil), want...)
+	damaged[10] ^= 0xff
+	damaged[2999] ^= 0xff
+	ioutil.WriteFile(filepath.Join(dir, "fixture.bin"), damaged, 0644)
+
+	set := NewSet()
+	for _, name := range []string{"fixture.par2", "fixture.vol0+2.par2"} {
+		if err := set.ReadFile(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("ReadFile(%q) returned %v", name, err)
+		}
+	}
+	if set.RecoveryBlocks != 2 {
+		t.Fatalf("ReadFile() got %d recovery blocks, want 2", set.RecoveryBlocks)
+	}
+
+	result, err := set.Verify(dir)
+	if err != nil {
+		t.Fatalf("Verify() returned %v", err)
+	}
+	if result.DamagedSlices() != 2 {
+		t.Fatalf("Verify() found %d damaged slices, want 2", result.DamagedSlices())
+	}
+
+	repair, err := set.Repair(dir, result)
+	if err != nil {
+		t.Fatalf("Repair() returned %v", err)
+	}
+	if repair.Repaired != 2 || repair.Unrepairable != 0 {
+		t.Errorf("Repair() returned %+v, want 2 repaired", repair)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "fixture.bin"))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("fixture.bin differs from the original after repair, err %v", err)
+	}
+}
+
+func TestTooManySlices(t *testing.T) {
+	var files bytes.Buffer
+	main := binary.LittleEndian.AppendUint64(nil, 4)
+	main = binary.LittleEndian.AppendUint32(main, maxInputSlices+1)
+	main = append(main, make([]byte, 16*(maxInputSlices+1))...)
+	writePacket(&files, ID{}, typeMain, main)
+	if err := NewSet().Read(&files); err != errTooManySlices {
+		t.Errorf("Read() with %d files returned %v, want errTooManySlices", maxInputSlices+1, err)
+	}
+
+	var slices bytes.Buffer
+	for _, id := range []ID{{1}, {2}} {
+		ifsc := append(id[:], make([]byte, 20*(maxInputSlices/2+1))...)
+		writePacket(&slices, ID{}, typeIFSC, ifsc)
+	}
+	if err := NewSet().Read(&slices); err != errTooManySlices {
+		t.Errorf("Read() with %d slices returned %v, want errTooManySlices", maxInputSlices+2, err)
+	}
+
+	// A file description can claim any length.
+	index, _ := buildSet([]string{"a.bin"}, map[string][]byte{"a.bin": []byte("abcdefgh")}, 4)
+	set := NewSet()
+	if err := set.Read(index); err != nil {
+		t.Fatalf("Read() returned %v", err)
+	}
+	for _, file := range set.Files {
+		file.Length = 4 * (maxInputSlices + 1)
+	}
+	set.recovery = []recoverySlice{{exponent: 0}}
+	result := &Result{Files: []FileResult{{Damaged: []int{0}}}}
+	if _, err := set.Repair(t.TempDir(), result); err != errTooManySlices {
+		t.Errorf("Repair() returned %v, want errTooManySlices", err)
+	}
+}
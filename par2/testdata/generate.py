@@ -0,0 +1,112 @@
+#!/usr/bin/env python3
+"""Writes the PAR2 fixture in this directory.
+
+The packets are built straight from the PAR 2.0 specification
+(https://parchive.github.io/doc/Parity%20Volume%20Set%20Specification%20v2.0.html)
+and laid out the way par2cmdline lays out "par2 create -s1024 -c2 -n1":
+an index file with the critical packets and a creator packet, and one
+volume file holding both recovery slices. It shares no code with the Go
+package, so repairing with this fixture checks the package against the
+spec rather than against its own encoder.
+"""
+
+import hashlib
+import random
+import struct
+import zlib
+
+NAME = "fixture.bin"
+SLICE_SIZE = 1024
+RECOVERY = 2
+MAGIC = b"PAR2\0PKT"
+CREATOR = b"Created by generate.py from the PAR 2.0 specification"
+
+# GF(2^16) with generator polynomial x^16 + x^12 + x^3 + x + 1.
+EXP = [0] * 65535
+LOG = [0] * 65536
+x = 1
+for i in range(65535):
+    EXP[i] = x
+    LOG[x] = i
+    x <<= 1
+    if x & 0x10000:
+        x ^= 0x1100B
+
+
+def mul(a, b):
+    if a == 0 or b == 0:
+        return 0
+    return EXP[(LOG[a] + LOG[b]) % 65535]
+
+
+def power(a, n):
+    result = 1
+    for _ in range(n):
+        result = mul(result, a)
+    return result
+
+
+def constants(count):
+    """Input slice constants: 2^n for n with no factor in common with 65535."""
+    result = []
+    n = 0
+    while len(result) < count:
+        n += 1
+        if n % 3 and n % 5 and n % 17 and n % 257:
+            result.append(EXP[n])
+    return result
+
+
+def packet(set_id, kind, body):
+    kind = kind.ljust(16, b"\0")
+    hashed = set_id + kind + body
+    return MAGIC + struct.pack("<Q", 64 + len(body)) + hashlib.md5(hashed).digest() + hashed
+
+
+def pad4(data):
+    return data + b"\0" * (-len(data) % 4)
+
+
+def main():
+    rng = random.Random(2026)
+    data = bytes(rng.getrandbits(8) for _ in range(3000))
+
+    md5_16k = hashlib.md5(data[:16384]).digest()
+    file_id = hashlib.md5(md5_16k + struct.pack("<Q", len(data)) + NAME.encode()).digest()
+
+    slices = [data[i:i + SLICE_SIZE].ljust(SLICE_SIZE, b"\0") for i in range(0, len(data), SLICE_SIZE)]
+
+    main_body = struct.pack("<QI", SLICE_SIZE, 1) + file_id
+    set_id = hashlib.md5(main_body).digest()
+
+    critical = [
+        packet(set_id, b"PAR 2.0\0Main", main_body),
+        packet(set_id, b"PAR 2.0\0FileDesc",
+               file_id + hashlib.md5(data).digest() + md5_16k + struct.pack("<Q", len(data)) + pad4(NAME.encode())),
+        packet(set_id, b"PAR 2.0\0IFSC",
+               file_id + b"".join(hashlib.md5(s).digest() + struct.pack("<I", zlib.crc32(s)) for s in slices)),
+    ]
+    creator = packet(set_id, b"PAR 2.0\0Creator", pad4(CREATOR))
+
+    bases = constants(len(slices))
+    volume = b""
+    for exponent in range(RECOVERY):
+        words = [0] * (SLICE_SIZE // 2)
+        for base, s in zip(bases, slices):
+            factor = power(base, exponent)
+            for j in range(len(words)):
+                words[j] ^= mul(factor, struct.unpack_from("<H", s, 2 * j)[0])
+        recovery = b"".join(struct.pack("<H", w) for w in words)
+        volume += packet(set_id, b"PAR 2.0\0RecvSlic", struct.pack("<I", exponent) + recovery)
+        volume += b"".join(critical)
+
+    with open(NAME, "wb") as f:
+        f.write(data)
+    with open("fixture.par2", "wb") as f:
+        f.write(b"".join(critical) + creator)
+    with open("fixture.vol0+2.par2", "wb") as f:
+        f.write(volume + creator)
+
+
+if __name__ == "__main__":
+    main()
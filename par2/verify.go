@@ -0,0 +1,133 @@
+package par2
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileResult is the outcome of verifying one file.
+type FileResult struct {
+	Name string
+	// Missing is set if the file does not exist at all.
+	Missing bool
+	Slices  int
+	// Damaged holds the indexes of the slices that failed their checksums.
+	Damaged []int
+}
+
+func (f *FileResult) OK() bool {
+	return !f.Missing && len(f.Damaged) == 0
+}
+
+// Result is the outcome of verifying a recovery set.
+type Result struct {
+	SliceSize int64
+	Files     []FileResult
+}
+
+// DamagedSlices returns the number of slices that need repairing.
+func (r *Result) DamagedSlices() int {
+	damaged := 0
+	for _, file := range r.Files {
+		damaged += len(file.Damaged)
+	}
+
+	return damaged
+}
+
+func (r *Result) OK() bool {
+	return r.DamagedSlices() == 0
+}
+
+// Verify checks each file in the recovery set, by name, in dir.
+func (s *Set) Verify(dir string) (*Result, error) {
+	files, err := s.RecoveryFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{SliceSize: s.SliceSize}
+	for _, file := range files {
+		fileResult, err := s.verifyFile(file, filepath.Join(dir, file.Name))
+		if err != nil {
+			return nil, err
+		}
+		result.Files = append(result.Files, *fileResult)
+	}
+
+	return result, nil
+}
+
+func (s *Set) verifyFile(file *File, path string) (*FileResult, error) {
+	result := &FileResult{Name: file.Name, Slices: file.SliceCount(s.SliceSize)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		result.Missing = true
+		result.Damaged = allSlices(result.Slices)
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	whole := md5.New()
+	slice := make([]byte, s.SliceSize)
+	var damaged []int
+	for i := 0; i < result.Slices; i++ {
+		n, err := io.ReadFull(f, slice)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		remaining := file.Length - int64(i)*s.SliceSize
+		if int64(n) > remaining {
+			n = int(remaining)
+		}
+		whole.Write(slice[:n])
+
+		// The last slice is checksummed as if padded with zeros.
+		for j := n; j < len(slice); j++ {
+			slice[j] = 0
+		}
+
+		if i < len(file.Slices) && !sliceMatches(slice, file.Slices[i]) {
+			damaged = append(damaged, i)
+		}
+	}
+
+	if !bytes.Equal(whole.Sum(nil), file.MD5[:]) {
+		if len(file.Slices) == 0 {
+			// Without slice checksums there's no telling which are bad.
+			damaged = allSlices(result.Slices)
+		}
+	} else {
+		damaged = nil
+	}
+
+	result.Damaged = damaged
+
+	return result, nil
+}
+
+func sliceMatches(slice []byte, checksum SliceChecksum) bool {
+	if crc32.ChecksumIEEE(slice) != checksum.CRC32 {
+		return false
+	}
+
+	return md5.Sum(slice) == checksum.MD5
+}
+
+func allSlices(count int) []int {
+	slices := make([]int, count)
+	for i := range slices {
+		slices[i] = i
+	}
+
+	return slices
+}